@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"url_shortner/internal/data"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A failed batch insert is retried flushAttempts times in all, waiting
+// flushBackoff before the first retry and twice as long before each next one,
+// as a busy or briefly unreachable database should not cost a whole batch.
+const (
+	flushAttempts = 3
+	flushBackoff  = 100 * time.Millisecond
+)
+
+// analyticsPipeline queues analytics entries in memory and writes them to the
+// store in batches from a background worker, keeping the insert off the redirect path.
+type analyticsPipeline struct {
+	store     data.AnalyticsStore
+	queue     chan *data.AnalyticsEntry
+	batchSize int
+	interval  time.Duration
+	prepare   func(ctx context.Context, batch []*data.AnalyticsEntry)
+	logError  func(err error)
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	enqueued atomic.Int64
+	dropped  atomic.Int64
+	flushed  atomic.Int64
+	failed   atomic.Int64
+}
+
+// newAnalyticsPipeline starts a pipeline holding up to queueSize pending entries and
+// flushing every interval or as soon as batchSize entries are waiting. prepare is
+// applied to every batch right before it is written.
+func newAnalyticsPipeline(store data.AnalyticsStore, queueSize int, batchSize int, interval time.Duration, prepare func(ctx context.Context, batch []*data.AnalyticsEntry), logError func(err error)) *analyticsPipeline {
+	p := &analyticsPipeline{
+		store:     store,
+		queue:     make(chan *data.AnalyticsEntry, queueSize),
+		batchSize: batchSize,
+		interval:  interval,
+		prepare:   prepare,
+		logError:  logError,
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Enqueue hands the entry to the background worker without blocking. The entry
+// is dropped when the queue is full or the pipeline has been closed.
+func (p *analyticsPipeline) Enqueue(entry *data.AnalyticsEntry) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		p.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case p.queue <- entry:
+		p.enqueued.Add(1)
+		return true
+	default:
+		p.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting entries and blocks until everything already queued has been flushed.
+func (p *analyticsPipeline) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	<-p.done
+}
+
+// Pending returns the number of entries waiting in the queue.
+func (p *analyticsPipeline) Pending() int {
+	return len(p.queue)
+}
+
+func (p *analyticsPipeline) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	batch := make([]*data.AnalyticsEntry, 0, p.batchSize)
+	for {
+		select {
+		case entry, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= p.batchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (p *analyticsPipeline) flush(batch []*data.AnalyticsEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, span := tracer.Start(context.Background(), "analytics.flush", trace.WithAttributes(attribute.Int("batch.size", len(batch))))
+	defer span.End()
+
+	p.prepare(ctx, batch)
+
+	var err error
+	backoff := flushBackoff
+	for attempt := 1; attempt <= flushAttempts; attempt++ {
+		err = p.store.InsertBatch(ctx, batch)
+		if err == nil {
+			p.flushed.Add(int64(len(batch)))
+			return
+		}
+		span.RecordError(err)
+		if attempt < flushAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	p.logError(err)
+
+	// The batch is written in one transaction, so nothing of it was stored.
+	// Entries are then inserted one by one, so that an entry the store rejects
+	// only loses itself.
+	var failed int64
+	var lastErr error
+	for _, entry := range batch {
+		err = p.store.Insert(ctx, entry)
+		if err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		p.flushed.Add(1)
+	}
+	if failed > 0 {
+		p.failed.Add(failed)
+		p.logError(fmt.Errorf("dropped %d of %d analytics entries: %w", failed, len(batch), lastErr))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"url_shortner/internal/data"
+)
+
+// stubAnalyticsStore records the entries written to it. Its first
+// batchFailures calls to InsertBatch fail, as do inserts of entries whose user
+// agent is "reject".
+type stubAnalyticsStore struct {
+	data.AnalyticsStore
+
+	batchFailures int
+	batchCalls    int
+	insertCalls   int
+	stored        []*data.AnalyticsEntry
+}
+
+func (s *stubAnalyticsStore) InsertBatch(ctx context.Context, entries []*data.AnalyticsEntry) error {
+	s.batchCalls++
+	if s.batchCalls <= s.batchFailures {
+		return errors.New("database is locked")
+	}
+	s.stored = append(s.stored, entries...)
+	return nil
+}
+
+func (s *stubAnalyticsStore) Insert(ctx context.Context, entry *data.AnalyticsEntry) error {
+	s.insertCalls++
+	if entry.UserAgent == "reject" {
+		return errors.New("constraint failed")
+	}
+	s.stored = append(s.stored, entry)
+	return nil
+}
+
+// newTestPipeline starts a pipeline writing to store that only flushes on
+// full batches and on Close, and counts the errors it logs.
+func newTestPipeline(store data.AnalyticsStore, queueSize int, batchSize int, prepare func(ctx context.Context, batch []*data.AnalyticsEntry)) (*analyticsPipeline, *int) {
+	if prepare == nil {
+		prepare = func(ctx context.Context, batch []*data.AnalyticsEntry) {}
+	}
+	logged := new(int)
+	return newAnalyticsPipeline(store, queueSize, batchSize, time.Hour, prepare, func(err error) { *logged++ }), logged
+}
+
+func TestAnalyticsPipelineFlush(t *testing.T) {
+	tests := []struct {
+		name          string
+		batchFailures int
+		userAgents    []string
+		wantBatch     int
+		wantInserts   int
+		wantStored    int
+		wantFailed    int64
+		wantLogged    int
+	}{
+		{
+			name:       "batch written",
+			userAgents: []string{"a", "b", "c"},
+			wantBatch:  1,
+			wantStored: 3,
+		},
+		{
+			name:          "batch written on a retry",
+			batchFailures: flushAttempts - 1,
+			userAgents:    []string{"a", "b", "c"},
+			wantBatch:     flushAttempts,
+			wantStored:    3,
+		},
+		{
+			name:          "batch failing falls back to single inserts",
+			batchFailures: flushAttempts,
+			userAgents:    []string{"a", "reject", "c"},
+			wantBatch:     flushAttempts,
+			wantInserts:   3,
+			wantStored:    2,
+			wantFailed:    1,
+			wantLogged:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &stubAnalyticsStore{batchFailures: tt.batchFailures}
+			p, logged := newTestPipeline(store, 10, 100, nil)
+
+			for _, userAgent := range tt.userAgents {
+				p.Enqueue(&data.AnalyticsEntry{UserAgent: userAgent})
+			}
+			p.Close()
+
+			if store.batchCalls != tt.wantBatch || store.insertCalls != tt.wantInserts {
+				t.Errorf("got %d batch and %d single inserts, want %d and %d", store.batchCalls, store.insertCalls, tt.wantBatch, tt.wantInserts)
+			}
+			if len(store.stored) != tt.wantStored || p.flushed.Load() != int64(tt.wantStored) {
+				t.Errorf("stored %d entries and counted %d flushed, want %d", len(store.stored), p.flushed.Load(), tt.wantStored)
+			}
+			if p.failed.Load() != tt.wantFailed {
+				t.Errorf("counted %d failed entries, want %d", p.failed.Load(), tt.wantFailed)
+			}
+			if *logged != tt.wantLogged {
+				t.Errorf("logged %d errors, want %d", *logged, tt.wantLogged)
+			}
+		})
+	}
+}
+
+func TestAnalyticsPipelineDrain(t *testing.T) {
+	store := &stubAnalyticsStore{}
+	flushing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	// Every flush holds the worker until released, so the queue fills up.
+	p, _ := newTestPipeline(store, 1, 1, func(ctx context.Context, batch []*data.AnalyticsEntry) {
+		flushing <- struct{}{}
+		<-release
+	})
+
+	if !p.Enqueue(&data.AnalyticsEntry{UserAgent: "a"}) {
+		t.Fatal("the first entry was dropped")
+	}
+	<-flushing
+	if !p.Enqueue(&data.AnalyticsEntry{UserAgent: "b"}) {
+		t.Fatal("the entry filling the queue was dropped")
+	}
+	if p.Enqueue(&data.AnalyticsEntry{UserAgent: "c"}) {
+		t.Fatal("an entry past a full queue was accepted")
+	}
+	if p.Pending() != 1 {
+		t.Errorf("got %d pending entries, want 1", p.Pending())
+	}
+
+	app := newTestApplication(t, config{})
+	app.analytics = p
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := app.drain(ctx); err == nil {
+		t.Error("draining a stuck pipeline did not time out")
+	}
+
+	close(release)
+	if err := app.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.stored) != 2 || p.Pending() != 0 {
+		t.Errorf("stored %d entries with %d pending after closing, want 2 and 0", len(store.stored), p.Pending())
+	}
+	if p.Enqueue(&data.AnalyticsEntry{UserAgent: "d"}) {
+		t.Error("an entry was accepted after closing")
+	}
+	if p.enqueued.Load() != 2 || p.dropped.Load() != 2 {
+		t.Errorf("counted %d enqueued and %d dropped, want 2 and 2", p.enqueued.Load(), p.dropped.Load())
+	}
+}
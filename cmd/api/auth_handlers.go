@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strings"
@@ -41,7 +42,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.Models.Users.Insert(user)
+	err = app.Models.Users.Insert(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -53,16 +54,18 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	go func() {
+	// The mail outlives the request, so it keeps the trace but not the cancellation.
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
 		data := map[string]interface{}{
 			"username": user.Username,
 		}
 
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err := app.sendMail(ctx, user.Email, "user_welcome.tmpl", data)
 		if err != nil {
 			app.logResponse(r, err)
 		}
-	}()
+	})
 
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user})
 	if err != nil {
@@ -93,7 +96,7 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := app.Models.Users.GetByEmail(input.Email)
+	user, err := app.Models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -115,7 +118,7 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := app.Models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.Models.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -150,7 +153,7 @@ func (app *application) changeEmailHandler(w http.ResponseWriter, r *http.Reques
 
 	user := app.getUserFromContext(r)
 
-	err = app.Models.Users.SetEmail(user.ID, input.Email)
+	err = app.Models.Users.SetEmail(r.Context(), user.ID, input.Email)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -184,13 +187,13 @@ func (app *application) changePassswordHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	err = app.Models.Users.SetPassword(user.ID, string(user.Password.Hash))
+	err = app.Models.Users.SetPassword(r.Context(), user.ID, string(user.Password.Hash))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.Models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	err = app.Models.Tokens.DeleteAllForUser(r.Context(), data.ScopeAuthentication, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -220,7 +223,7 @@ func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	user, err := app.Models.Users.GetByEmail(input.Email)
+	user, err := app.Models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.writeJSON(w, http.StatusOK, envelope{"message": "An email has been sent to your account with instructions"})
@@ -230,23 +233,25 @@ func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := app.Models.Tokens.New(user.ID, 10*time.Minute, data.ScopeAuthentication)
+	token, err := app.Models.Tokens.New(r.Context(), user.ID, 10*time.Minute, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	go func() {
+	// The mail outlives the request, so it keeps the trace but not the cancellation.
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
 		data := map[string]interface{}{
 			"username": user.Username,
 			"token":    token,
 		}
 
-		err = app.mailer.Send(user.Email, "reset_password.tmpl", data)
+		err := app.sendMail(ctx, user.Email, "reset_password.tmpl", data)
 		if err != nil {
 			app.logResponse(r, err)
 		}
-	}()
+	})
 
 	app.writeJSON(w, http.StatusOK, envelope{"message": "An email has been sent to your account with instructions"})
 }
@@ -258,7 +263,7 @@ func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request
 		app.authenticationRequiredResponse(w, r)
 	}
 
-	err := app.Models.Tokens.DeleteOneForUser(tokenPlaintext)
+	err := app.Models.Tokens.DeleteOneForUser(r.Context(), tokenPlaintext)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -269,7 +274,7 @@ func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request
 func (app *application) registerPremiumHandler(w http.ResponseWriter, r *http.Request) {
 	user := app.getUserFromContext(r)
 	if user.Type != 2 {
-		err := app.Models.Users.SetUserType(user.ID, 2)
+		err := app.Models.Users.SetUserType(r.Context(), user.ID, 2)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
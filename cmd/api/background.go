@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// background runs fn in its own goroutine, tracked so that shutdown waits for it
+// to finish. A panic in fn is logged instead of bringing down the server.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logError(fmt.Errorf("background task panicked: %v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// drain stops the periodic jobs, then waits for tracked background tasks and
+// queued analytics to finish, giving up once ctx is done.
+func (app *application) drain(ctx context.Context, stops ...func()) error {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for _, stop := range stops {
+			stop()
+		}
+		app.wg.Wait()
+		app.analytics.Close()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background tasks did not finish before the drain timeout: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"url_shortner/internal/backup"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// errBackupUnsupported is returned by backup and restore for backends other than SQLite.
+var errBackupUnsupported = errors.New("backups are only supported for the sqlite backend")
+
+// runBackup writes an online copy of the SQLite database to the backup folder
+// and deletes the copies past the configured number kept.
+func (app *application) runBackup(ctx context.Context) (b backup.Backup, err error) {
+	ctx, span := tracer.Start(ctx, "backup.run")
+	defer func() { endSpan(span, err) }()
+
+	if app.db == nil || app.config.backend() != "sqlite" {
+		return backup.Backup{}, errBackupUnsupported
+	}
+
+	app.backupRuns.Add(1)
+	b, err = backup.Create(ctx, app.db, app.config.backup.dir, app.config.backup.keep)
+	if err != nil {
+		app.backupFailed.Add(1)
+		return backup.Backup{}, err
+	}
+
+	span.SetAttributes(attribute.String("backup.path", b.Path))
+	app.logger.Info("database backed up", "path", b.Path)
+	return b, nil
+}
+
+// startBackups backs the SQLite database up every backup interval, the first
+// time one interval after start. It returns a function that stops the
+// schedule, waiting for a backup in progress.
+func (app *application) startBackups() (stop func()) {
+	if app.config.backup.interval <= 0 || app.config.backend() != "sqlite" {
+		return func() {}
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(app.config.backup.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+
+			_, err := app.runBackup(context.Background())
+			if err != nil {
+				app.logError(err)
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// checkBackup reports when the newest backup was taken. It does not fail on
+// an old backup, as that is no reason to stop routing traffic here.
+func (app *application) checkBackup(ctx context.Context) (envelope, error) {
+	if app.config.backend() != "sqlite" {
+		return nil, errCheckSkipped
+	}
+
+	latest, err := backup.Latest(app.config.backup.dir)
+	if err != nil {
+		if errors.Is(err, backup.ErrNoBackup) {
+			return envelope{"last_backup": nil}, nil
+		}
+		return nil, err
+	}
+	return envelope{
+		"last_backup": latest.Time,
+		"age_seconds": int64(time.Since(latest.Time).Seconds()),
+		"path":        latest.Path,
+	}, nil
+}
+
+// backupCommand takes a backup on demand. It can run while the server is up.
+func (app *application) backupCommand(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	b, err := app.runBackup(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Backed up to", b.Path)
+	return nil
+}
+
+// runRestoreCommand swaps the SQLite database for a backup, once the backup
+// proved readable and at a schema version this release can run, migrating it
+// up on the next start if it is older. The server must be stopped first.
+func runRestoreCommand(cfg config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if cfg.backend() != "sqlite" {
+		return errBackupUnsupported
+	}
+
+	files, err := migrationFiles(cfg)
+	if err != nil {
+		return err
+	}
+	latest, err := latestMigration(files)
+	if err != nil {
+		return err
+	}
+
+	var version uint
+	previous, err := backup.Restore(args[0], sqlitePath(cfg.database.dsn), func(db *sql.DB) error {
+		var dirty bool
+		err := db.QueryRow(`SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+		switch {
+		case err != nil:
+			return fmt.Errorf("reading the schema version of the backup: %w", err)
+		case dirty:
+			return fmt.Errorf("the backup was taken while migration %d was failing", version)
+		case version > latest:
+			return fmt.Errorf("the backup is at schema version %d, newer than the %d this release knows", version, latest)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %s at schema version %d\n", args[0], version)
+	if previous != "" {
+		fmt.Println("The replaced database was moved to", previous)
+	}
+	if version < latest {
+		fmt.Printf("It is migrated to version %d on the next start\n", latest)
+	}
+	return nil
+}
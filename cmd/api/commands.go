@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"url_shortner/internal/data"
+	"url_shortner/internal/useragent"
+	"url_shortner/internal/validator"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// commandUsage lists the maintenance commands, printed when one is misused.
+const commandUsage = `commands:
+  migrate up | down [steps] | version | recover | force <version>
+  user create [-name name] [-premium] <email> <password>
+  user promote | demote | delete <email>
+  link list <email>
+  link disable | delete [-domain host] <code>
+  link transfer [-domain host] <code> <email>
+  tokens revoke <email> [authentication|reset]
+  stats [-domain host] [-days n] [-include-bots] <code>
+  backup
+  restore <backup file>
+  backfill-useragents`
+
+// errUsage reports a command called with the wrong arguments. The usage is
+// printed along with it.
+var errUsage = errors.New("invalid arguments")
+
+// offlineCommands run without opening the stores, as they operate on the
+// database itself and must not have it migrated first.
+var offlineCommands = map[string]func(cfg config, args []string) error{
+	"migrate": runMigrateCommand,
+	"restore": runRestoreCommand,
+}
+
+// runCommand executes a one-off maintenance command named by the first
+// non-flag argument instead of starting the HTTP server.
+func (app *application) runCommand(args []string) error {
+	ctx := context.Background()
+
+	switch args[0] {
+	case "user":
+		return app.userCommand(ctx, args[1:])
+	case "link":
+		return app.linkCommand(ctx, args[1:])
+	case "tokens":
+		return app.tokensCommand(ctx, args[1:])
+	case "stats":
+		return app.statsCommand(ctx, args[1:])
+	case "backup":
+		return app.backupCommand(ctx, args[1:])
+	case "backfill-useragents":
+		return app.backfillUserAgents(ctx)
+	default:
+		return fmt.Errorf("unknown command %q: %w", args[0], errUsage)
+	}
+}
+
+// runMigrateCommand applies, rolls back or reports migrations, or recovers
+// from a failed one. Unlike the other commands it runs before the schema is
+// brought up to date on start.
+func runMigrateCommand(cfg config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	db, err := openDB(cfg.backend(), cfg.database.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := newMigrate(db, cfg)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = m.Up()
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errUsage
+			}
+		}
+		err = m.Steps(-steps)
+	case args[0] == "force" && len(args) == 2:
+		var version int
+		version, err = strconv.Atoi(args[1])
+		if err != nil || version < -1 {
+			return errUsage
+		}
+		err = m.Force(version)
+	case args[0] == "recover" && len(args) == 1:
+		var files fs.FS
+		files, err = migrationFiles(cfg)
+		if err == nil {
+			err = recoverMigration(m, files)
+		}
+	case args[0] == "version" && len(args) == 1:
+	default:
+		return errUsage
+	}
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		fmt.Println("No migration applied")
+	case err != nil:
+		return err
+	case dirty:
+		fmt.Printf("Schema version %d (dirty, see migrate recover)\n", version)
+	default:
+		fmt.Printf("Schema version %d\n", version)
+	}
+	return nil
+}
+
+func (app *application) userCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	if args[0] == "create" {
+		return app.createUser(ctx, args[1:])
+	}
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	user, err := app.userByEmail(ctx, args[1])
+	if err != nil {
+		return err
+	}
+
+	var outcome string
+	switch args[0] {
+	case "promote":
+		err = app.Models.Users.SetUserType(ctx, user.ID, 2)
+		outcome = "is now premium"
+	case "demote":
+		err = app.Models.Users.SetUserType(ctx, user.ID, 1)
+		outcome = "is no longer premium"
+	case "delete":
+		err = app.Models.Users.Delete(ctx, user.ID)
+		outcome = "deleted with their links"
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("User", user.Email, outcome)
+	return nil
+}
+
+func (app *application) createUser(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	name := fs.String("name", "", "Display name, the part of the email before @ when empty")
+	premium := fs.Bool("premium", false, "Create a premium user")
+	if fs.Parse(args) != nil || fs.NArg() != 2 {
+		return errUsage
+	}
+
+	user := &data.User{
+		Username: *name,
+		Email:    strings.ToLower(fs.Arg(0)),
+		Type:     1,
+	}
+	if user.Username == "" {
+		user.Username, _, _ = strings.Cut(user.Email, "@")
+	}
+	if *premium {
+		user.Type = 2
+	}
+
+	err := user.Password.Set(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	data.ValidateUser(v, user)
+	if !v.Valid() {
+		return validationError(v)
+	}
+
+	err = app.Models.Users.Insert(ctx, user)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			return fmt.Errorf("a user with email %q already exists", user.Email)
+		}
+		return err
+	}
+
+	fmt.Printf("User %s created with id %d\n", user.Email, user.ID)
+	return nil
+}
+
+func (app *application) linkCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	if args[0] == "list" {
+		if len(args) != 2 {
+			return errUsage
+		}
+		user, err := app.userByEmail(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		urls, err := app.Models.URLS.GetAllForUser(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		return printJSON(envelope{"urls": urls})
+	}
+
+	fs := flag.NewFlagSet("link "+args[0], flag.ContinueOnError)
+	domain := fs.String("domain", "", "Short domain the code lives on, the default domain when empty")
+	if fs.Parse(args[1:]) != nil || fs.NArg() == 0 {
+		return errUsage
+	}
+
+	url, err := app.Models.URLS.GetByShort(ctx, data.NormalizeHost(*domain), fs.Arg(0))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("no link %q", fs.Arg(0))
+		}
+		return err
+	}
+
+	var outcome string
+	switch {
+	case args[0] == "disable" && fs.NArg() == 1:
+		// An expired link answers 410 Gone and is removed by the next janitor pass.
+		url.Expired = time.Now()
+		err = app.Models.URLS.Update(ctx, url)
+		outcome = "disabled"
+	case args[0] == "delete" && fs.NArg() == 1:
+		err = app.Models.URLS.DeleteByShort(ctx, url.Domain, url.ShortCode)
+		if err == nil {
+			err = app.Models.Analytics.DeleteByURLID(ctx, url.ID)
+		}
+		outcome = "deleted with its analytics"
+	case args[0] == "transfer" && fs.NArg() == 2:
+		var user *data.User
+		user, err = app.userByEmail(ctx, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		url.UserID = user.ID
+		err = app.Models.URLS.Update(ctx, url)
+		outcome = "transferred to " + user.Email
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Link", url.ShortCode, outcome)
+	return nil
+}
+
+func (app *application) tokensCommand(ctx context.Context, args []string) error {
+	if len(args) < 2 || len(args) > 3 || args[0] != "revoke" {
+		return errUsage
+	}
+
+	scopes := []string{data.ScopeAuthentication, data.ScopeReset}
+	if len(args) == 3 {
+		if !validator.New().In(args[2], scopes...) {
+			return errUsage
+		}
+		scopes = args[2:]
+	}
+
+	user, err := app.userByEmail(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	for _, scope := range scopes {
+		err = app.Models.Tokens.DeleteAllForUser(ctx, scope, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Revoked the %s tokens of %s\n", strings.Join(scopes, " and "), user.Email)
+	return nil
+}
+
+// statsCommand prints the same analytics summary as GET /api/stats/{shortCode}.
+func (app *application) statsCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	domain := fs.String("domain", "", "Short domain the code lives on, the default domain when empty")
+	days := fs.Int("days", 30, "Number of days summarized, up to now")
+	includeBots := fs.Bool("include-bots", false, "Count clicks flagged as bot traffic")
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	url, err := app.Models.URLS.GetByShort(ctx, data.NormalizeHost(*domain), fs.Arg(0))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("no link %q", fs.Arg(0))
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	filter := data.AnalyticsFilter{
+		From:        now.AddDate(0, 0, -*days),
+		To:          now,
+		Interval:    data.IntervalDay,
+		Top:         10,
+		IncludeBots: *includeBots,
+	}
+	v := validator.New()
+	data.ValidateAnalyticsFilter(v, filter)
+	if !v.Valid() {
+		return validationError(v)
+	}
+
+	summary, err := app.Models.Analytics.Summarize(ctx, url.ID, filter)
+	if err != nil {
+		return err
+	}
+	return printJSON(envelope{"url": url, "stats": summary})
+}
+
+// userByEmail looks up the user a command names, with a readable error when there is none.
+func (app *application) userByEmail(ctx context.Context, email string) (*data.User, error) {
+	user, err := app.Models.Users.GetByEmail(ctx, strings.ToLower(email))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no user with email %q", email)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// validationError turns the failed checks of v into one error.
+func validationError(v *validator.Validator) error {
+	problems := make([]string, 0, len(v.Errors))
+	for key, message := range v.Errors {
+		problems = append(problems, key+" "+message)
+	}
+	return errors.New(strings.Join(problems, ", "))
+}
+
+// printJSON writes data to stdout indented the way the API responds.
+func printJSON(data envelope) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(js))
+	return err
+}
+
+// backfillUserAgents parses the user agent of every analytics entry recorded
+// before browser, OS and device were stored alongside it.
+func (app *application) backfillUserAgents(ctx context.Context) error {
+	const batchSize = 500
+
+	var afterID, total int64
+	for {
+		entries, err := app.Models.Analytics.GetWithoutAgentDetails(ctx, afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		for _, entry := range entries {
+			entry.ParseUserAgent()
+			entry.Bot = entry.Device == useragent.DeviceBot
+		}
+		err = app.Models.Analytics.SetAgentDetails(ctx, entries)
+		if err != nil {
+			return err
+		}
+
+		afterID = entries[len(entries)-1].ID
+		total += int64(len(entries))
+	}
+
+	fmt.Println("Backfilled user agent details for", total, "analytics entries")
+	return nil
+}
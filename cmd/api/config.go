@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"url_shortner/internal/clientip"
+	"url_shortner/internal/data"
+	"url_shortner/internal/validator"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the name of every environment variable read by loadConfig.
+// The rest of the name is the flag name upper-cased with dashes turned into
+// underscores, so -smtp-password is read from CHOPPER_SMTP_PASSWORD.
+const envPrefix = "CHOPPER_"
+
+// loadConfig fills the flags of fs from, in increasing order of precedence, their
+// defaults, the config file named by -config or CHOPPER_CONFIG, CHOPPER_*
+// environment variables and the command line arguments in args.
+func loadConfig(fs *flag.FlagSet, args []string) error {
+	var path string
+	fs.StringVar(&path, "config", "", "Path to a JSON or YAML configuration file (also read from "+envPrefix+"CONFIG)")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	// Flags given on the command line win over everything else.
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+		err = setFlags(fs, explicit, values, "config file "+path)
+		if err != nil {
+			return err
+		}
+	}
+
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			values[f.Name] = value
+		}
+	})
+	return setFlags(fs, explicit, values, "environment")
+}
+
+// envName returns the environment variable read for the named flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// setFlags sets every flag named in values that was not given on the command line.
+func setFlags(fs *flag.FlagSet, explicit map[string]bool, values map[string]string, source string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", source, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		err := fs.Set(name, values[name])
+		if err != nil {
+			return fmt.Errorf("%s: invalid value for %q: %w", source, name, err)
+		}
+	}
+	return nil
+}
+
+// readConfigFile reads a JSON, YAML or TOML file, picked by its extension, into flag
+// values. Keys are flag names; nested objects join their keys with a dash, so
+// {"smtp": {"host": "..."}} sets -smtp-host. Lists become comma-separated values.
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// Numbers are kept as written, where float64 would turn 1000000 into 1e+06.
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &raw)
+	case ".toml":
+		err = toml.Unmarshal(content, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, use .json, .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", raw, values)
+	return values, nil
+}
+
+func flattenConfig(prefix string, raw map[string]interface{}, values map[string]string) {
+	for key, value := range raw {
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flattenConfig(key, value, values)
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = configString(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = configString(value)
+		}
+	}
+}
+
+// configString formats a single value from a config file as a flag value.
+func configString(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// placeholderSecrets are values left in sample configuration that must never reach production.
+var placeholderSecrets = []string{"changeme", "change-me", "change_me", "placeholder", "secret", "password", "null", "todo", "xxx"}
+
+// isPlaceholder reports whether a secret still holds an example value.
+func isPlaceholder(secret string) bool {
+	lower := strings.ToLower(strings.TrimSpace(secret))
+	if strings.HasPrefix(lower, "<") && strings.HasSuffix(lower, ">") {
+		return true
+	}
+	for _, placeholder := range placeholderSecrets {
+		if lower == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// validateConfig rejects configuration the server cannot run with.
+func validateConfig(cfg config) error {
+	v := validator.New()
+
+	v.Check(cfg.Port > 0 && cfg.Port <= 65535, "port", "must be between 1 and 65535")
+	if cfg.baseURL != "" {
+		base, err := url.Parse(cfg.baseURL)
+		v.Check(err == nil && (base.Scheme == "http" || base.Scheme == "https") && base.Host != "", "base-url", "must be an absolute http or https URL")
+		v.Check(err == nil && base.RawQuery == "" && base.Fragment == "", "base-url", "must not have a query or fragment")
+	}
+	for _, host := range cfg.shortDomains {
+		data.ValidateHost(v, "short-domains", host)
+	}
+	v.Check(cfg.domainTimeout > 0, "domain-verify-timeout", "must be greater than zero")
+	v.Check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-key", "must be provided together with tls-cert")
+	if cfg.tls.redirectPort != 0 {
+		v.Check(cfg.tls.certFile != "", "tls-redirect-port", "requires tls-cert and tls-key")
+		v.Check(cfg.tls.redirectPort > 0 && cfg.tls.redirectPort <= 65535, "tls-redirect-port", "must be between 1 and 65535")
+		v.Check(cfg.tls.redirectPort != cfg.Port, "tls-redirect-port", "must differ from port")
+	}
+	v.Check(cfg.tls.hstsMaxAge >= 0, "hsts-max-age", "must not be negative")
+	v.Check(cfg.tls.certFile == "" || cfg.tls.reloadInterval > 0, "tls-reload-interval", "must be greater than zero")
+	v.Check(cfg.shutdownTimeout > 0, "shutdown-timeout", "must be greater than zero")
+	if _, err := clientip.NewResolver(cfg.trustedProxies); err != nil {
+		v.AddError("trusted-proxies", "must be IP addresses or CIDR ranges")
+	}
+	if cfg.rateLimiter.enabled {
+		v.Check(cfg.rateLimiter.rps > 0, "limiter-rps", "must be greater than zero")
+		v.Check(cfg.rateLimiter.burst > 0, "limiter-burst", "must be greater than zero")
+	}
+	if cfg.dailyLimiter.enabled {
+		v.Check(cfg.dailyLimiter.anonymous > 0, "dailyLimiter-ip", "must be greater than zero")
+		v.Check(cfg.dailyLimiter.authenticated > 0, "dailyLimiter-id", "must be greater than zero")
+	}
+
+	v.Check(v.In(cfg.database.backend, "", "sqlite", "postgres", "memory"), "db-backend", "must be one of 'sqlite', 'postgres' or 'memory'")
+	v.Check(cfg.database.dsn != "" || cfg.database.backend == "memory", "dsn", "must be provided")
+	v.Check(cfg.readiness.minFreeMB >= 0, "readyz-min-free-mb", "must not be negative")
+	v.Check(cfg.database.queryTimeout > 0, "db-query-timeout", "must be greater than zero")
+
+	v.Check(cfg.cache.size >= 0, "cache-size", "must not be negative")
+	v.Check(cfg.cache.size == 0 || cfg.cache.ttl > 0, "cache-ttl", "must be greater than zero")
+	v.Check(cfg.analytics.queueSize > 0, "analytics-queue", "must be greater than zero")
+	v.Check(cfg.analytics.batchSize > 0, "analytics-batch", "must be greater than zero")
+	v.Check(cfg.analytics.flushInterval > 0, "analytics-flush-interval", "must be greater than zero")
+	v.Check(cfg.privacy.purgeInterval > 0, "analytics-purge-interval", "must be greater than zero")
+	v.Check(cfg.janitor.interval >= 0, "janitor-interval", "must not be negative")
+	v.Check(cfg.backup.interval >= 0, "backup-interval", "must not be negative")
+	v.Check(cfg.backup.keep >= 1, "backup-keep", "must be at least 1")
+
+	v.Check(v.In(cfg.tracing.exporter, "none", "stdout", "file"), "trace-exporter", "must be one of 'none', 'stdout' or 'file'")
+	v.Check(cfg.tracing.exporter != "file" || cfg.tracing.file != "", "trace-file", "must be provided with the file exporter")
+	v.Check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "trace-sample-ratio", "must be between 0 and 1")
+	v.Check(v.In(cfg.log.level, "debug", "info", "warn", "error"), "log-level", "must be one of 'debug', 'info', 'warn' or 'error'")
+	v.Check(v.In(cfg.log.format, "json", "text"), "log-format", "must be either 'json' or 'text'")
+
+	v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	v.Check(cfg.smtp.sender != "", "smtp-sender", "must be provided")
+	v.Check(cfg.smtp.username == "" || cfg.smtp.password != "", "smtp-password", "must be provided with smtp-username")
+	v.Check(!isPlaceholder(cfg.smtp.username), "smtp-username", "must not be a placeholder value")
+	v.Check(cfg.smtp.password == "" || !isPlaceholder(cfg.smtp.password), "smtp-password", "must not be a placeholder value")
+	v.Check(cfg.privacy.ipSalt == "" || !isPlaceholder(cfg.privacy.ipSalt), "privacy-ip-salt", "must not be a placeholder value")
+
+	if !v.Valid() {
+		return fmt.Errorf("invalid configuration: %v", v.Errors)
+	}
+	return validatePrivacyConfig(cfg)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSettings are the flags loadConfig is tested against.
+type testSettings struct {
+	port    int64
+	rps     float64
+	queue   int
+	host    string
+	proxies string
+	debug   bool
+}
+
+func newTestFlagSet(settings *testSettings) *flag.FlagSet {
+	fs := flag.NewFlagSet("chopper", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Int64Var(&settings.port, "port", 8080, "")
+	fs.Float64Var(&settings.rps, "limiter-rps", 2, "")
+	fs.IntVar(&settings.queue, "analytics-queue", 10000, "")
+	fs.StringVar(&settings.host, "smtp-host", "localhost", "")
+	fs.StringVar(&settings.proxies, "trusted-proxies", "", "")
+	fs.BoolVar(&settings.debug, "debug", false, "")
+	return fs
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // config file name and content, separated by a newline
+		env     map[string]string
+		args    []string
+		want    testSettings
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			want: testSettings{port: 8080, rps: 2, queue: 10000, host: "localhost"},
+		},
+		{
+			name: "JSON file",
+			file: "chopper.json\n" + `{"port": 4000, "analytics-queue": 1000000, "limiter": {"rps": 2.5}, "smtp": {"host": "mail.example"}, "trusted-proxies": ["10.0.0.0/8", "::1"], "debug": true}`,
+			want: testSettings{port: 4000, rps: 2.5, queue: 1000000, host: "mail.example", proxies: "10.0.0.0/8,::1", debug: true},
+		},
+		{
+			name: "YAML file",
+			file: "chopper.yaml\nport: 4000\nanalytics-queue: 1000000\nsmtp:\n  host: mail.example\ntrusted-proxies:\n  - 10.0.0.0/8\n",
+			want: testSettings{port: 4000, rps: 2, queue: 1000000, host: "mail.example", proxies: "10.0.0.0/8"},
+		},
+		{
+			name: "TOML file",
+			file: "chopper.toml\nport = 4000\ntrusted-proxies = [\"10.0.0.0/8\", \"::1\"]\n[smtp]\nhost = \"mail.example\"\n",
+			want: testSettings{port: 4000, rps: 2, queue: 10000, host: "mail.example", proxies: "10.0.0.0/8,::1"},
+		},
+		{
+			name: "environment over file",
+			file: "chopper.json\n" + `{"port": 4000, "smtp": {"host": "mail.example"}}`,
+			env:  map[string]string{"CHOPPER_PORT": "5000", "CHOPPER_LIMITER_RPS": "7"},
+			want: testSettings{port: 5000, rps: 7, queue: 10000, host: "mail.example"},
+		},
+		{
+			name: "flags over environment and file",
+			file: "chopper.json\n" + `{"port": 4000, "smtp": {"host": "mail.example"}}`,
+			env:  map[string]string{"CHOPPER_PORT": "5000", "CHOPPER_SMTP_HOST": "env.example"},
+			args: []string{"-port", "6000"},
+			want: testSettings{port: 6000, rps: 2, queue: 10000, host: "env.example"},
+		},
+		{
+			name: "file named by the environment",
+			file: "chopper.json\n" + `{"port": 4000}`,
+			env:  map[string]string{"CHOPPER_CONFIG": "chopper.json"},
+			want: testSettings{port: 4000, rps: 2, queue: 10000, host: "localhost"},
+		},
+		{
+			name:    "unknown key",
+			file:    "chopper.json\n" + `{"smtp": {"hots": "mail.example"}}`,
+			wantErr: `unknown setting "smtp-hots"`,
+		},
+		{
+			name:    "invalid value in the file",
+			file:    "chopper.yaml\nport: eighty\n",
+			wantErr: `invalid value for "port"`,
+		},
+		{
+			name:    "invalid value in the environment",
+			env:     map[string]string{"CHOPPER_DEBUG": "maybe"},
+			wantErr: `environment: invalid value for "debug"`,
+		},
+		{
+			name:    "unsupported format",
+			file:    "chopper.ini\nport=4000\n",
+			wantErr: "unsupported format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := tt.args
+			if tt.file != "" {
+				name, content, _ := strings.Cut(tt.file, "\n")
+				path := filepath.Join(dir, name)
+				err := os.WriteFile(path, []byte(content), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.env["CHOPPER_CONFIG"] == "" {
+					args = append([]string{"-config", path}, args...)
+				}
+			}
+			for key, value := range tt.env {
+				if key == "CHOPPER_CONFIG" {
+					value = filepath.Join(dir, value)
+				}
+				t.Setenv(key, value)
+			}
+
+			var got testSettings
+			err := loadConfig(newTestFlagSet(&got), args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPlaceholder(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   bool
+	}{
+		{"changeme", true},
+		{"  CHANGE-ME ", true},
+		{"Secret", true},
+		{"<your smtp password>", true},
+		{"xxx", true},
+		{"", false},
+		{"s3cr3t-0f-4ny-l3ngth", false},
+		{"<not closed", false},
+		{"password123", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPlaceholder(tt.secret); got != tt.want {
+			t.Errorf("isPlaceholder(%q) = %t, want %t", tt.secret, got, tt.want)
+		}
+	}
+}
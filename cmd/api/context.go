@@ -11,9 +11,14 @@ type contextKey string
 const userContextKey = contextKey("user")
 const authTokenPlaintextContextKey = contextKey("auth_token_plaintext")
 const urlContextKey = contextKey("url")
+const clientIPContextKey = contextKey("client_ip")
+const requestInfoContextKey = contextKey("request_info")
 
 func (app *application) setUserInContext(r *http.Request, user *data.User) *http.Request {
 
+	if info := app.getRequestInfoFromContext(r); info != nil && !user.IsAnonymous() {
+		info.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -52,3 +57,27 @@ func (app *application) getAuthTokenPlaintextFromContext(r *http.Request) string
 	}
 	return token
 }
+
+func (app *application) setClientIPInContext(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+func (app *application) getClientIPFromContext(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if !ok {
+		panic("missing client ip value in request context")
+	}
+	return ip
+}
+
+func (app *application) setRequestInfoInContext(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// getRequestInfoFromContext returns nil outside of a request handled by the requestID middleware.
+func (app *application) getRequestInfoFromContext(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
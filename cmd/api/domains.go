@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"url_shortner/internal/data"
+	"url_shortner/internal/domainverify"
+	"url_shortner/internal/validator"
+
+	"github.com/go-chi/chi"
+)
+
+// isShortDomain reports whether host is one of the deployment's short domains.
+func (app *application) isShortDomain(host string) bool {
+	for _, domain := range app.config.shortDomains {
+		if domain == host {
+			return true
+		}
+	}
+	return false
+}
+
+// baseHost returns the host of the public base URL, or "" when none is configured.
+func (app *application) baseHost() string {
+	base, err := url.Parse(app.config.baseURL)
+	if err != nil {
+		return ""
+	}
+	return data.NormalizeHost(base.Host)
+}
+
+// domainForHost returns the short domain a request's Host header resolves codes
+// on. Hosts that are neither deployment nor verified custom domains use the
+// default domain, which is stored as "". For a custom domain it also returns
+// the user who verified it, the only one whose links it may resolve; owner is
+// 0 on the shared domains.
+func (app *application) domainForHost(ctx context.Context, host string) (domain string, owner int64, err error) {
+	host = data.NormalizeHost(host)
+	if host == "" || host == app.baseHost() {
+		return "", 0, nil
+	}
+	if app.isShortDomain(host) {
+		return host, 0, nil
+	}
+
+	registered, err := app.Models.Domains.GetVerified(ctx, host)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return "", 0, nil
+		}
+		return "", 0, err
+	}
+	return host, registered.UserID, nil
+}
+
+// canUseDomain reports whether user may create short links on domain: a
+// deployment domain, or a custom domain they verified.
+func (app *application) canUseDomain(ctx context.Context, user *data.User, domain string) (bool, error) {
+	if domain == "" || app.isShortDomain(domain) {
+		return true, nil
+	}
+	if user.IsAnonymous() {
+		return false, nil
+	}
+
+	registered, err := app.Models.Domains.GetVerified(ctx, domain)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return registered.UserID == user.ID, nil
+}
+
+// shortURL returns the full short link of a URL on its domain.
+func (app *application) shortURL(r *http.Request, link *data.URL) string {
+	if link.Domain == "" {
+		return app.deployedURL(r) + link.ShortCode
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if base, err := url.Parse(app.config.baseURL); err == nil && base.Scheme != "" {
+		scheme = base.Scheme
+	}
+	return scheme + "://" + link.Domain + "/" + link.ShortCode
+}
+
+func (app *application) listDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromContext(r)
+
+	domains, err := app.Models.Domains.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	shared := app.config.shortDomains
+	if shared == nil {
+		shared = []string{}
+	}
+	app.writeJSON(w, http.StatusOK, envelope{"shared": shared, "domains": domains})
+}
+
+func (app *application) createDomainHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Host string `json:"host"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromContext(r)
+	if !user.IsPremium() {
+		app.premiumRequiredResponse(w, r)
+		return
+	}
+
+	domain, err := data.NewDomain(user.ID, data.NormalizeHost(input.Host))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateHost(v, "host", domain.Host)
+	v.Check(!app.isShortDomain(domain.Host) && domain.Host != app.baseHost(), "host", "is already used by this deployment")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Hosts someone already proved control of cannot be claimed again.
+	_, err = app.Models.Domains.GetVerified(r.Context(), domain.Host)
+	if err == nil {
+		app.createConflictResponse(w, r)
+		return
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.Models.Domains.Insert(r.Context(), domain)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEntry):
+			app.createConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"domain": domain, "verification": verificationInstructions(domain)})
+}
+
+// verificationInstructions tells the owner of an unverified domain where to publish its token.
+func verificationInstructions(domain *data.Domain) envelope {
+	return envelope{
+		"dns": envelope{
+			"type":  "TXT",
+			"name":  domainverify.RecordPrefix + domain.Host,
+			"value": domainverify.RecordValuePrefix + domain.Token,
+		},
+		"http": envelope{
+			"url":  "http://" + domain.Host + domainverify.WellKnownPath,
+			"body": domain.Token,
+		},
+	}
+}
+
+func (app *application) verifyDomainHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromContext(r)
+	if !user.IsPremium() {
+		app.premiumRequiredResponse(w, r)
+		return
+	}
+
+	domain, err := app.Models.Domains.Get(r.Context(), user.ID, data.NormalizeHost(chi.URLParam(r, "host")))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if domain.Verified {
+		app.writeJSON(w, http.StatusOK, envelope{"domain": domain})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.domainTimeout)
+	defer cancel()
+
+	method, err := app.verifier.Verify(ctx, domain.Host, domain.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, domainverify.ErrNotVerified):
+			app.domainNotVerifiedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.Models.Domains.MarkVerified(r.Context(), domain)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEntry):
+			app.createConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Links can only be created on a verified domain, so any left on it belong
+	// to an earlier owner and would otherwise take codes from the new one.
+	_, err = app.Models.URLS.DeleteByDomain(r.Context(), domain.Host)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"domain": domain, "method": method})
+}
+
+func (app *application) deleteDomainHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromContext(r)
+	host := data.NormalizeHost(chi.URLParam(r, "host"))
+
+	domain, err := app.Models.Domains.Get(r.Context(), user.ID, host)
+	if err == nil {
+		err = app.Models.Domains.Delete(r.Context(), user.ID, host)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The links of a released domain go with it, so that whoever verifies it
+	// next starts without them. A pending claim has none, and removing it must
+	// not touch the links of the user who verified the domain meanwhile.
+	if domain.Verified {
+		_, err = app.Models.URLS.DeleteByDomain(r.Context(), host)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusNoContent, envelope{})
+}
@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"url_shortner/internal/data"
 )
 
 func (app *application) logResponse(r *http.Request, err error) {
-	fmt.Println("Error:", r.Method, r.URL.String(), "  : ", err)
+	app.requestLogger(r).Error(err.Error())
 }
 
+// logError reports errors that happen outside of a request, such as in background workers.
+func (app *application) logError(err error) {
+	app.logger.Error(err.Error())
+}
+
+// errorResponse sends message with the request ID, so that a user reporting an
+// error can be matched with the server's logs.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
+	if info := app.getRequestInfoFromContext(r); info != nil {
+		env["request_id"] = info.id
+	}
 	err := app.writeJSON(w, status, env)
 	if err != nil {
 		app.logResponse(r, err)
@@ -19,10 +32,35 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 }
 
 // sends a JSON-encoded error response with a generic error message and status code.
+// Queries that ran out of time or found the database busy get a 504 or 503 instead.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	message := "the server encountered a problem and could not process your request"
-	http.Error(w, message, http.StatusInternalServerError)
-	app.logResponse(r, err)
+	switch {
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		// The client went away; there is nobody left to read the response.
+		app.requestLogger(r).Info("request canceled", "error", err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case data.IsTimeout(err):
+		app.logResponse(r, err)
+		app.timeoutResponse(w, r)
+	case data.IsUnavailable(err):
+		app.logResponse(r, err)
+		app.serviceUnavailableResponse(w, r)
+	default:
+		app.logResponse(r, err)
+		message := "the server encountered a problem and could not process your request"
+		app.errorResponse(w, r, http.StatusInternalServerError, message)
+	}
+}
+
+func (app *application) timeoutResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the server took too long to process your request, please try again later"
+	app.errorResponse(w, r, http.StatusGatewayTimeout, message)
+}
+
+func (app *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	message := "the server is busy and could not process your request, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
 }
 
 func (app *application) NotFoundResponse(w http.ResponseWriter, r *http.Request) {
@@ -79,6 +117,11 @@ func (app *application) authorizationRequiredResponse(w http.ResponseWriter, r *
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) domainNotVerifiedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the verification token was not found in the domain's TXT record or at its well-known path"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
 func (app *application) premiumRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "You require premium to access this feature"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
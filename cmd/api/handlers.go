@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 	"url_shortner/internal/data"
 	"url_shortner/internal/validator"
@@ -18,16 +23,12 @@ type inputURL struct {
 	LongURL  string `json:"long"`
 	ShortURL string `json:"short"`
 	Redirect string `json:"redirect"`
+	Domain   string `json:"domain"` // Domain is the short domain the code is created on, the default domain when empty.
 	UserID   int64  `json:"-"`
 	New      bool   `json:"new"`
 	Once     bool   `json:"once"` // Once is used to specify that this short url will be deleted as soon as it is used once.
 }
 
-// health check message.
-func (app *application) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	app.writeJSON(w, http.StatusOK, envelope{"message": "OK"})
-}
-
 // URL shortening requests.
 func (app *application) CreateShortURLHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -51,10 +52,20 @@ func (app *application) CreateShortURLHandler(w http.ResponseWriter, r *http.Req
 			v.Check(len(input.ShortURL) >= 6, "short", "must be greater than or equal to 6  chars")
 		}
 		v.Check(v.Matches(input.ShortURL, validator.ShortCodeRX), "short", "should containe characters from a-z,A-Z, 0-9")
+		v.Check(!v.In(input.ShortURL, data.ReservedShortCodes...), "short", "is reserved")
 	}
 	if input.Redirect != "" {
 		v.Check(input.Redirect == "permanent" || input.Redirect == "temporary", "redirect", "must be either 'permanent' or  'temporary'")
 	}
+	input.Domain = data.NormalizeHost(input.Domain)
+	if input.Domain != "" {
+		allowed, err := app.canUseDomain(r.Context(), user, input.Domain)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(allowed, "domain", "must be one of your verified domains or a domain of this deployment")
+	}
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -89,22 +100,22 @@ func (app *application) AuthenticatedShortenURLHandler(w http.ResponseWriter, r
 	}
 	//If no custom code is required
 	if input.ShortURL == "" && !input.New {
-		existingURL, err := app.Models.URLS.GetByLongURL(input.LongURL, redirectType, input.UserID)
+		existingURL, err := app.Models.URLS.GetByLongURL(r.Context(), input.Domain, input.LongURL, redirectType, input.UserID)
 		if err != nil && err != data.ErrRecordNotFound {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 		if existingURL != nil {
 			existingURL.Expired = time.Now().Add(24 * time.Hour)
-			app.Models.URLS.Update(existingURL)
-			app.writeJSON(w, http.StatusOK, envelope{"url": existingURL, "short_url": (getDeployedURL(r) + existingURL.ShortCode)})
+			app.Models.URLS.Update(r.Context(), existingURL)
+			app.writeJSON(w, http.StatusOK, envelope{"url": existingURL, "short_url": app.shortURL(r, existingURL)})
 			return
 		}
 	}
 
-	url = data.NewURL(input.LongURL, input.ShortURL, redirectType, user, input.Once)
+	url = data.NewURL(input.Domain, input.LongURL, input.ShortURL, redirectType, user, input.Once)
 
-	err := app.Models.URLS.Insert(url)
+	err := app.Models.URLS.Insert(r.Context(), url)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEntry):
@@ -116,8 +127,7 @@ func (app *application) AuthenticatedShortenURLHandler(w http.ResponseWriter, r
 		}
 	}
 
-	hostURL := getDeployedURL(r)
-	app.writeJSON(w, http.StatusCreated, envelope{"url": url, "short_url": (hostURL + url.ShortCode)})
+	app.writeJSON(w, http.StatusCreated, envelope{"url": url, "short_url": app.shortURL(r, url)})
 }
 
 func (app *application) AnonymousShortenURLHandler(w http.ResponseWriter, r *http.Request, input *inputURL) {
@@ -125,7 +135,7 @@ func (app *application) AnonymousShortenURLHandler(w http.ResponseWriter, r *htt
 	user := app.getUserFromContext(r)
 
 	// if the URL already exists in the database.
-	existingURL, err := app.Models.URLS.GetByLongURL(input.LongURL, http.StatusPermanentRedirect, input.UserID)
+	existingURL, err := app.Models.URLS.GetByLongURL(r.Context(), input.Domain, input.LongURL, http.StatusPermanentRedirect, input.UserID)
 	if err != nil && err != data.ErrRecordNotFound {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -133,14 +143,14 @@ func (app *application) AnonymousShortenURLHandler(w http.ResponseWriter, r *htt
 	expiryTime := time.Now().Add(6 * time.Hour)
 	if existingURL != nil {
 		existingURL.Expired = expiryTime
-		app.Models.URLS.Update(existingURL)
-		app.writeJSON(w, http.StatusOK, envelope{"url": existingURL, "short_url": (getDeployedURL(r) + existingURL.ShortCode)})
+		app.Models.URLS.Update(r.Context(), existingURL)
+		app.writeJSON(w, http.StatusOK, envelope{"url": existingURL, "short_url": app.shortURL(r, existingURL)})
 		return
 	}
 
-	url = data.NewURL(input.LongURL, "", http.StatusPermanentRedirect, user, false)
+	url = data.NewURL(input.Domain, input.LongURL, "", http.StatusPermanentRedirect, user, false)
 
-	err = app.Models.URLS.Insert(url)
+	err = app.Models.URLS.Insert(r.Context(), url)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEntry):
@@ -152,8 +162,7 @@ func (app *application) AnonymousShortenURLHandler(w http.ResponseWriter, r *htt
 		}
 	}
 
-	hostURL := getDeployedURL(r)
-	app.writeJSON(w, http.StatusCreated, envelope{"url": url, "short_url": (hostURL + url.ShortCode)})
+	app.writeJSON(w, http.StatusCreated, envelope{"url": url, "short_url": app.shortURL(r, url)})
 }
 
 func (app *application) EditShortURLHandler(w http.ResponseWriter, r *http.Request) {
@@ -172,6 +181,7 @@ func (app *application) EditShortURLHandler(w http.ResponseWriter, r *http.Reque
 	if input.ShortURL != "" {
 		v.Check(len(input.ShortURL) >= 4, "short", "must be greater than 3 chars")
 		v.Check(v.Matches(input.ShortURL, validator.ShortCodeRX), "short", "should containe characters from a-z,A-Z, 0-9")
+		v.Check(!v.In(input.ShortURL, data.ReservedShortCodes...), "short", "is reserved")
 	}
 	if input.Redirect != "" {
 		v.Check(input.Redirect == "permanent" || input.Redirect == "temporary", "redirect", "must be either 'permanent' or  'temporary'")
@@ -200,26 +210,25 @@ func (app *application) EditShortURLHandler(w http.ResponseWriter, r *http.Reque
 
 	url.Expired = time.Now()
 
-	err = app.Models.URLS.Update(url)
+	err = app.Models.URLS.Update(r.Context(), url)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	hostURL := getDeployedURL(r)
-	app.writeJSON(w, http.StatusAccepted, envelope{"url": url, "short_url": (hostURL + url.ShortCode)})
+	app.writeJSON(w, http.StatusAccepted, envelope{"url": url, "short_url": app.shortURL(r, url)})
 }
 
 func (app *application) DeleteShortURLHandler(w http.ResponseWriter, r *http.Request) {
 	url := app.getURLFromContext(r)
 
-	err := app.Models.URLS.DeleteByShort(url.ShortCode)
+	err := app.Models.URLS.DeleteByShort(r.Context(), url.Domain, url.ShortCode)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	app.writeJSON(w, http.StatusNoContent, envelope{})
-	err = app.Models.Analytics.DeleteByURLID(url.ID)
+	err = app.Models.Analytics.DeleteByURLID(r.Context(), url.ID)
 	if err != nil {
 		app.logResponse(r, err)
 	}
@@ -227,14 +236,13 @@ func (app *application) DeleteShortURLHandler(w http.ResponseWriter, r *http.Req
 
 func (app *application) GetShortURLHandler(w http.ResponseWriter, r *http.Request) {
 	url := app.getURLFromContext(r)
-	hostURL := getDeployedURL(r)
-	app.writeJSON(w, http.StatusOK, envelope{"url": url, "short_url": (hostURL + url.ShortCode)})
+	app.writeJSON(w, http.StatusOK, envelope{"url": url, "short_url": app.shortURL(r, url)})
 }
 
 func (app *application) GetAllShortsHandler(w http.ResponseWriter, r *http.Request) {
 	user := app.getUserFromContext(r)
 
-	urls, err := app.Models.URLS.GetAllForUser(user.ID)
+	urls, err := app.Models.URLS.GetAllForUser(r.Context(), user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -246,11 +254,23 @@ func (app *application) GetAllShortsHandler(w http.ResponseWriter, r *http.Reque
 func (app *application) ExpandURLHandler(w http.ResponseWriter, r *http.Request) {
 	shortCode := chi.URLParam(r, "shortCode")
 
-	url, err := app.Models.URLS.GetByShort(shortCode)
+	// The same code can exist on several domains, so it is looked up on the one the request came in on.
+	domain, owner, err := app.domainForHost(r.Context(), r.Host)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	url, err := app.Models.URLS.GetByShort(r.Context(), domain, shortCode)
+	if err == nil && owner != 0 && url.UserID != owner {
+		// Left behind by an earlier owner of the custom domain.
+		err = data.ErrRecordNotFound
+	}
 	if err != nil {
 		switch {
 
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.prom.redirects.WithLabelValues(redirectNotFound).Inc()
 			app.NotFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -259,59 +279,163 @@ func (app *application) ExpandURLHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	if url.Expired.Before(time.Now()) {
+		app.prom.redirects.WithLabelValues(redirectExpired).Inc()
 		app.expiredLinkResponse(w, r)
-		app.Models.URLS.DeleteByShort(shortCode)
+		app.Models.URLS.DeleteByShort(r.Context(), domain, shortCode)
 		return
 	}
 
+	bot := isBotRequest(r)
+
 	if url.UserID != data.AnonymousUser.ID {
 		analyticsEntry := data.AnalyticsEntry{
-			IP:        r.RemoteAddr,
+			IP:        app.getClientIPFromContext(r),
 			UserAgent: r.UserAgent(),
 			Referrer:  r.Referer(),
 			Timestamp: time.Now(),
 			URLID:     url.ID,
+			OwnerID:   url.UserID,
+			Bot:       bot,
 		}
 
-		err = app.Models.Analytics.Insert(&analyticsEntry)
-		if err != nil {
-			app.logResponse(r, err)
-		}
+		analyticsEntry.ParseUserAgent()
+		analyticsEntry.SetLocation(app.geo.Lookup(analyticsEntry.IP))
+		app.analytics.Enqueue(&analyticsEntry)
 	}
 
-	if url.Once {
+	// Preview fetchers unfurl links before anyone clicks them, so only a person uses up a Once link.
+	if url.Once && !bot {
 		url.Expired = time.Now()
-		err = app.Models.URLS.Update(url)
+		err = app.Models.URLS.Update(r.Context(), url)
 		if err != nil {
 			app.logResponse(r, err)
 		}
 	}
 
+	app.prom.redirects.WithLabelValues(redirectFound).Inc()
 	http.Redirect(w, r, url.LongForm, url.Redirect)
 
 }
 
-// analytics for a given short URL.
+// aggregated analytics for a given short URL, bucketed by hour, day or week over a from/to range.
 func (app *application) AnalyticsHandler(w http.ResponseWriter, r *http.Request) {
 
 	url := app.getURLFromContext(r)
-	analytics, err := app.Models.Analytics.GetByURLID(url.ID)
+
+	v := validator.New()
+	qs := r.URL.Query()
+	now := time.Now().UTC()
+
+	filter := data.AnalyticsFilter{
+		From:     app.readTime(qs, "from", now.AddDate(0, 0, -30), v),
+		To:       app.readTime(qs, "to", now, v),
+		Interval: app.readString(qs, "interval", data.IntervalDay),
+		Top:      app.readInt(qs, "top", 10, v),
+
+		IncludeBots: app.readBool(qs, "include_bots", false, v),
+	}
+	if v.Valid() {
+		data.ValidateAnalyticsFilter(v, filter)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	summary, err := app.Models.Analytics.Summarize(r.Context(), url.ID, filter)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	hostURL := getDeployedURL(r)
-	app.writeJSON(w, http.StatusOK, envelope{"short_url": (hostURL + url.ShortCode), "analytics": analytics})
+	app.writeJSON(w, http.StatusOK, envelope{"short_url": app.shortURL(r, url), "analytics": summary})
+}
+
+// streams the raw analytics of a short URL as CSV or newline-delimited JSON.
+func (app *application) ExportAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	url := app.getURLFromContext(r)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	from := app.readTime(qs, "from", time.Unix(0, 0), v)
+	to := app.readTime(qs, "to", time.Now(), v)
+	format := app.readString(qs, "format", exportFormatFromAccept(r.Header.Get("Accept")))
+
+	v.Check(to.After(from), "to", "must be after from")
+	v.Check(format == "csv" || format == "ndjson", "format", "must be either 'csv' or 'ndjson'")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var writeEntry func(entry *data.AnalyticsEntry) error
+	var flush func() error
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"accessed_at", "ip_address", "user_agent", "referrer", "browser", "browser_version", "os", "device", "country", "region", "city", "bot"})
+		writeEntry = func(entry *data.AnalyticsEntry) error {
+			return cw.Write([]string{entry.Timestamp.UTC().Format(time.RFC3339), entry.IP, entry.UserAgent, entry.Referrer,
+				entry.Browser, entry.BrowserVersion, entry.OS, entry.Device, entry.Country, entry.Region, entry.City, strconv.FormatBool(entry.Bot)})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		writeEntry = func(entry *data.AnalyticsEntry) error {
+			return enc.Encode(entry)
+		}
+		flush = func() error { return nil }
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", url.ShortCode+"-analytics."+format))
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are already sent, so from here on failures can only be logged.
+	flusher, _ := w.(http.Flusher)
+	rowsWritten := 0
+	err := app.Models.Analytics.Stream(r.Context(), url.ID, from, to, func(entry *data.AnalyticsEntry) error {
+		err := writeEntry(entry)
+		if err != nil {
+			return err
+		}
+		rowsWritten++
+		if rowsWritten%500 == 0 && flusher != nil {
+			if err := flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		app.logResponse(r, err)
+	}
+}
+
+// exportFormatFromAccept picks the export format requested by an Accept header, defaulting to CSV.
+func exportFormatFromAccept(accept string) string {
+	if strings.Contains(accept, "application/x-ndjson") || strings.Contains(accept, "application/ndjson") {
+		return "ndjson"
+	}
+	return "csv"
 }
 
 func (app *application) QRCodeHandler(w http.ResponseWriter, r *http.Request) {
-	shortCode := chi.URLParam(r, "shortCode")
-	imagePath := filepath.Join("./qrcodes", shortCode+".png")
+	url := app.getURLFromContext(r)
+	imagePath := filepath.Join(qrCodeDir, url.Domain, url.ShortCode+".png")
 	_, err := os.Stat(imagePath)
 
 	if os.IsNotExist(err) {
 		// Generate and save the QR code image
-		err := generateAndSaveQRCode(getDeployedURL(r)+shortCode, imagePath)
+		err := generateAndSaveQRCode(app.shortURL(r, url), imagePath)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
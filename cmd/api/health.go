@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds the whole of a /readyz probe. Checks still running
+// by then are reported as failed.
+const readinessTimeout = 2 * time.Second
+
+// errCheckSkipped is returned by readiness checks that do not apply to the
+// current configuration, such as pinging the database of the memory backend.
+var errCheckSkipped = errors.New("not applicable")
+
+// errDiskUnsupported is returned by freeDiskSpace where the platform offers no way to ask.
+var errDiskUnsupported = errors.New("free disk space is not available on this platform")
+
+// readinessCheck probes one dependency, returning details reported alongside its outcome.
+type readinessCheck func(ctx context.Context) (envelope, error)
+
+// healthzHandler tells the orchestrator the process is alive. It checks
+// nothing else, so a database outage does not get the process restarted.
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, envelope{"status": "ok"})
+}
+
+// readyzHandler runs every readiness check concurrently and reports each of
+// them, answering 503 when any failed so that no traffic is routed here.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := app.readinessChecks()
+	results := make(envelope, len(checks))
+	ready := true
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check readinessCheck) {
+			defer wg.Done()
+			result, ok := runCheck(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			ready = ready && ok
+		}(name, check)
+	}
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+		app.requestLogger(r).Warn("not ready", "checks", results)
+	}
+	app.writeJSON(w, code, envelope{"status": status, "checks": results})
+}
+
+// runCheck runs check until ctx is done, as some checks cannot be interrupted,
+// and returns its report and whether it passed.
+func runCheck(ctx context.Context, check readinessCheck) (envelope, bool) {
+	type outcome struct {
+		details envelope
+		err     error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		details, err := check(ctx)
+		done <- outcome{details, err}
+	}()
+
+	var res outcome
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = fmt.Errorf("timed out after %s", readinessTimeout)
+	}
+
+	report := envelope{"status": "ok", "duration_ms": float64(time.Since(start).Microseconds()) / 1000}
+	for key, value := range res.details {
+		report[key] = value
+	}
+	switch {
+	case errors.Is(res.err, errCheckSkipped):
+		return envelope{"status": "skipped"}, true
+	case res.err != nil:
+		report["status"] = "failed"
+		report["error"] = res.err.Error()
+		return report, false
+	}
+	return report, true
+}
+
+// readinessChecks returns the checks run by /readyz, by name.
+func (app *application) readinessChecks() map[string]readinessCheck {
+	checks := map[string]readinessCheck{
+		"database":   app.checkDatabase,
+		"migrations": app.checkMigrations,
+		"qrcodes_disk": func(ctx context.Context) (envelope, error) {
+			return app.checkDiskSpace(qrCodeDir)
+		},
+		"smtp":   app.checkSMTP,
+		"backup": app.checkBackup,
+	}
+	if app.config.backend() == "sqlite" {
+		checks["database_disk"] = func(ctx context.Context) (envelope, error) {
+			return app.checkDiskSpace(sqlitePath(app.config.database.dsn))
+		}
+	}
+	return checks
+}
+
+func (app *application) checkDatabase(ctx context.Context) (envelope, error) {
+	if app.db == nil {
+		return nil, errCheckSkipped
+	}
+	return nil, app.db.PingContext(ctx)
+}
+
+// checkMigrations compares the schema version recorded by the migration tool
+// with the newest migration shipped for the backend.
+func (app *application) checkMigrations(ctx context.Context) (envelope, error) {
+	if app.db == nil {
+		return nil, errCheckSkipped
+	}
+
+	files, err := migrationFiles(app.config)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := latestMigration(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var version uint
+	var dirty bool
+	err = app.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		return nil, err
+	}
+
+	details := envelope{"version": version, "expected": expected, "dirty": dirty}
+	switch {
+	case dirty:
+		return details, fmt.Errorf("migration %d did not complete", version)
+	case version != expected:
+		return details, fmt.Errorf("schema is at version %d, expected %d", version, expected)
+	}
+	return details, nil
+}
+
+// checkDiskSpace fails when the file system holding path has less free space
+// than configured. Paths not created yet are measured on their nearest parent.
+func (app *application) checkDiskSpace(path string) (envelope, error) {
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	free, err := freeDiskSpace(path)
+	if err != nil {
+		if errors.Is(err, errDiskUnsupported) {
+			return nil, errCheckSkipped
+		}
+		return nil, err
+	}
+
+	freeMB := free / (1 << 20)
+	details := envelope{"path": path, "free_mb": freeMB}
+	if freeMB < uint64(app.config.readiness.minFreeMB) {
+		return details, fmt.Errorf("only %d MB free, %d MB required", freeMB, app.config.readiness.minFreeMB)
+	}
+	return details, nil
+}
+
+// sqlitePath returns the file named by a SQLite DSN, which may be a file: URI
+// with connection parameters.
+func sqlitePath(dsn string) string {
+	path, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	return path
+}
+
+// checkSMTP connects to the mail server when -readyz-smtp asks for it, as a
+// server that cannot send sign-up and reset emails is only partly working.
+func (app *application) checkSMTP(ctx context.Context) (envelope, error) {
+	if !app.config.readiness.smtp {
+		return nil, errCheckSkipped
+	}
+	return envelope{"host": app.config.smtp.host}, app.mailer.Ping()
+}
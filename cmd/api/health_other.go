@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// freeDiskSpace is not implemented on this platform, so the disk checks of /readyz are skipped.
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errDiskUnsupported
+}
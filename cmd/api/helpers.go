@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+	"url_shortner/internal/useragent"
+	"url_shortner/internal/validator"
 
 	"github.com/skip2/go-qrcode"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // A generic map structure to package response.
@@ -83,6 +92,68 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// returns the query string value for key, or defaultValue when it is absent.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// returns the query string value for key as an integer, recording a validation error if it is not one.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+// returns the query string value for key as a boolean, recording a validation error if it is not one.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// returns the query string value for key as a time, accepting RFC 3339 timestamps or plain dates.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+	v.AddError(key, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	return defaultValue
+}
+
+// reports whether a request comes from an automated client rather than a person.
+// HEAD requests are only sent by link checkers and preview fetchers, never by a browser following a link.
+func isBotRequest(r *http.Request) bool {
+	return r.Method == http.MethodHead || useragent.IsBot(r.UserAgent())
+}
+
 func (app *application) isAuthenticated(r *http.Request) bool {
 	user := app.getUserFromContext(r)
 	return !user.IsAnonymous()
@@ -116,6 +187,10 @@ func getDeployedURL(r *http.Request) string {
 	return hostURL
 }
 
+// qrCodeDir holds the generated QR code images, one <shortCode>.png per short URL
+// on the default domain and <domain>/<shortCode>.png for the other short domains.
+const qrCodeDir = "./qrcodes"
+
 func generateAndSaveQRCode(shortCode string, imagePath string) error {
 
 	// Generate the QR code
@@ -125,6 +200,10 @@ func generateAndSaveQRCode(shortCode string, imagePath string) error {
 	}
 
 	// Create and save the image file
+	err = os.MkdirAll(filepath.Dir(imagePath), 0o755)
+	if err != nil {
+		return err
+	}
 	file, err := os.Create(imagePath)
 	if err != nil {
 		return err
@@ -139,3 +218,17 @@ func generateAndSaveQRCode(shortCode string, imagePath string) error {
 
 	return nil
 }
+
+// sendMail sends an email from templateFile in its own span and counts whether it went out.
+func (app *application) sendMail(ctx context.Context, recipient string, templateFile string, data interface{}) (err error) {
+	ctx, span := tracer.Start(ctx, "mail.send", trace.WithAttributes(attribute.String("mail.template", templateFile)))
+	defer func() { endSpan(span, err) }()
+
+	err = app.mailer.Send(ctx, recipient, templateFile, data)
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+	}
+	app.prom.mailSent.WithLabelValues(templateFile, outcome).Inc()
+	return err
+}
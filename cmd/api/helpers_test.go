@@ -0,0 +1,20 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"url_shortner/internal/data"
+)
+
+// newTestApplication returns an application on the memory backend with the
+// given configuration, logging nowhere.
+func newTestApplication(t *testing.T, cfg config) *application {
+	t.Helper()
+
+	return &application{
+		Models: data.NewMemoryModel(),
+		config: cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"url_shortner/internal/data"
+)
+
+// runJanitor deletes expired URLs with their analytics, expired tokens and QR
+// codes left behind for short codes that no longer exist.
+func (app *application) runJanitor(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "janitor.run")
+	defer func() { endSpan(span, err) }()
+
+	app.janitorRuns.Add(1)
+	now := time.Now()
+
+	urls, err := app.Models.URLS.DeleteExpired(ctx, now)
+	app.janitorURLs.Add(int64(len(urls)))
+	if err != nil {
+		return err
+	}
+
+	tokens, err := app.Models.Tokens.DeleteExpired(ctx, now)
+	if err != nil {
+		return err
+	}
+	app.janitorTokens.Add(tokens)
+
+	return app.removeOrphanQRCodes(ctx)
+}
+
+// removeOrphanQRCodes deletes the PNGs under qrCodeDir whose short code no longer
+// exists. Subdirectories hold the QR codes of the other short domains.
+func (app *application) removeOrphanQRCodes(ctx context.Context) error {
+	files, err := os.ReadDir(qrCodeDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			err = app.removeOrphanDomainQRCodes(ctx, file.Name())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return app.removeOrphanDomainQRCodes(ctx, "")
+}
+
+// removeOrphanDomainQRCodes deletes the orphaned PNGs of one short domain.
+func (app *application) removeOrphanDomainQRCodes(ctx context.Context, domain string) error {
+	dir := filepath.Join(qrCodeDir, domain)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		shortCode, ok := strings.CutSuffix(file.Name(), ".png")
+		if file.IsDir() || !ok {
+			continue
+		}
+
+		_, err := app.Models.URLS.GetByShort(ctx, domain, shortCode)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = os.Remove(filepath.Join(dir, file.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		app.janitorQRCodes.Add(1)
+	}
+
+	return nil
+}
+
+// startJanitor runs runJanitor on the configured interval until the returned
+// function is called. A zero interval disables the janitor.
+func (app *application) startJanitor() (stop func()) {
+	if app.config.janitor.interval <= 0 {
+		return func() {}
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(app.config.janitor.interval)
+		defer ticker.Stop()
+
+		for {
+			err := app.runJanitor(context.Background())
+			if err != nil {
+				app.janitorFailed.Add(1)
+				app.logError(err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the request ID in both directions, so a proxy in front
+// of the server can pick it and clients can quote it when reporting a problem.
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo collects what is learned about a request while it is handled.
+// Handlers down the chain fill it in, so the access log written once the
+// response is sent can include it.
+type requestInfo struct {
+	id     string
+	userID int64
+}
+
+// newLogger returns the structured logger writing to out at the configured level and format.
+func newLogger(out io.Writer, level string, format string) *slog.Logger {
+	var lvl slog.Level
+	lvl.UnmarshalText([]byte(level))
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	if format == "text" {
+		return slog.New(slog.NewTextHandler(out, opts))
+	}
+	return slog.New(slog.NewJSONHandler(out, opts))
+}
+
+// requestID takes the request ID from X-Request-ID, or generates one when the
+// header is missing or unusable, and echoes it in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		r = app.setRequestInfoInContext(r, &requestInfo{id: id})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts IDs of up to 128 printable characters without spaces,
+// keeping forged headers from breaking the log lines they end up in.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	return !strings.ContainsFunc(id, func(c rune) bool {
+		return c <= ' ' || c > '~'
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequests writes one access log line per request once the response is sent.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		level := slog.LevelInfo
+		if metrics.Code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		app.requestLogger(r).Log(r.Context(), level, "request",
+			"status", metrics.Code,
+			"bytes", metrics.Written,
+			"duration_ms", float64(metrics.Duration.Microseconds())/1000,
+			"user_agent", r.UserAgent(),
+		)
+	})
+}
+
+// requestLogger returns the logger for lines about r, carrying its request ID,
+// client address, route and, once known, the user and short code involved.
+func (app *application) requestLogger(r *http.Request) *slog.Logger {
+	attrs := []interface{}{"method", r.Method, "path", r.URL.Path}
+
+	if info := app.getRequestInfoFromContext(r); info != nil {
+		attrs = append(attrs, "request_id", info.id)
+		if info.userID != 0 {
+			attrs = append(attrs, "user_id", info.userID)
+		}
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		attrs = append(attrs, "trace_id", sc.TraceID().String())
+	}
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		// Logs keep addresses no longer than the deployment lets analytics keep them.
+		attrs = append(attrs, "ip", app.anonymizeIP(ip, app.config.privacy.ipMode))
+	}
+	// The router fills in the URL parameters of the request's route context after
+	// the outer middleware ran, so the short code is read from there.
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if shortCode := rctx.URLParam("shortCode"); shortCode != "" {
+			attrs = append(attrs, "short_code", shortCode)
+		}
+	}
+
+	return app.logger.With(attrs...)
+}
@@ -1,23 +1,42 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"url_shortner/internal/certs"
+	"url_shortner/internal/clientip"
 	"url_shortner/internal/data"
+	"url_shortner/internal/domainverify"
+	"url_shortner/internal/geoip"
 	"url_shortner/internal/mailer"
 
-	"github.com/golang-migrate/migrate/v4"
-	sqlite3 "github.com/golang-migrate/migrate/v4/database/sqlite3"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // config represents the configuration parameters for the application.
 type config struct {
-	Port        int64 // Port number
-	rateLimiter struct {
+	Port            int64         // Port number
+	baseURL         string        // Public base URL of short links, taken from each request when empty
+	shortDomains    []string      // Extra short domains every user can create links on
+	domainTimeout   time.Duration // How long checking a custom domain's verification token may take
+	shutdownTimeout time.Duration // How long in-flight requests and background tasks get to finish on shutdown
+	trustedProxies  []string      // CIDR ranges whose forwarding headers are trusted
+	debugVars       bool          // Serve the expvar counters on /debug/vars
+	rateLimiter     struct {
 		rps     float64 // Rate limiter maximum requests per second
 		burst   int     // Rate limiter maximum burst
 		enabled bool    // Enable rate limiter
@@ -28,8 +47,56 @@ type config struct {
 		enabled       bool
 	}
 	database struct {
-		dsn            string // Path to the SQLite database file
-		migrationsPath string
+		backend        string        // Storage backend: sqlite, postgres or memory
+		dsn            string        // Path to the SQLite database file or a postgres:// URL
+		migrationsPath string        // Folder of migration files, the embedded ones are used when empty
+		queryTimeout   time.Duration // Longest a single storage call may take
+	}
+	cache struct {
+		size int           // Maximum number of short URLs kept in the redirect cache, 0 disables it
+		ttl  time.Duration // How long a cached short URL is served before it is refetched
+	}
+	analytics struct {
+		queueSize     int           // Maximum number of clicks waiting to be written
+		batchSize     int           // Maximum number of clicks written in one transaction
+		flushInterval time.Duration // Longest time a click waits in the queue
+	}
+	privacy struct {
+		ipMode        string        // How client IPs are stored: none, truncate or hash
+		ipSalt        string        // Key for hashed IPs
+		retentionDays int           // Days analytics are kept, 0 keeps them forever
+		purgeInterval time.Duration // How often expired analytics are deleted
+	}
+	tls struct {
+		certFile       string        // Certificate served over HTTPS, TLS is off when empty
+		keyFile        string        // Private key of certFile
+		redirectPort   int64         // Port of a plain HTTP listener redirecting to HTTPS, 0 disables it
+		hstsMaxAge     time.Duration // Strict-Transport-Security max-age sent over HTTPS, 0 disables the header
+		reloadInterval time.Duration // How often the certificate files are checked for changes
+	}
+	janitor struct {
+		interval time.Duration // How often expired URLs, tokens and orphaned QR codes are removed, 0 disables it
+	}
+	backup struct {
+		dir      string        // Folder the SQLite backups are written to
+		interval time.Duration // How often the SQLite database is backed up, 0 disables it
+		keep     int           // Number of backups kept, older ones are deleted
+	}
+	tracing struct {
+		exporter    string  // Where spans go: none, stdout or file
+		file        string  // File spans are appended to with the file exporter
+		sampleRatio float64 // Share of new traces recorded, between 0 and 1
+	}
+	log struct {
+		level  string // Lowest level written: debug, info, warn or error
+		format string // Log line format: json or text
+	}
+	readiness struct {
+		minFreeMB int  // Free disk space /readyz requires for the database and QR codes
+		smtp      bool // Connect to the SMTP server in /readyz
+	}
+	geoip struct {
+		path string // MaxMind-format database used to locate clicks, lookups are skipped when empty
 	}
 	smtp struct {
 		host     string
@@ -42,79 +109,338 @@ type config struct {
 
 // application represents the main application structure.
 type application struct {
-	Models data.Model // Data model for the application
-	config config     // Application configuration
-	mailer mailer.Mailer
+	Models     data.Model   // Data model for the application
+	db         *sql.DB      // Connection pool behind Models, nil for the memory backend
+	config     config       // Application configuration
+	logger     *slog.Logger // Structured logger for the server and its background jobs
+	prom       *promMetrics // Collectors served on /metrics
+	mailer     mailer.Mailer
+	urlCache   *data.CachedURLModel   // Redirect cache in front of Models.URLS, nil when disabled
+	analytics  *analyticsPipeline     // Asynchronous writer for click analytics
+	geo        *geoip.DB              // Click location lookups, nil when no database is configured
+	ipResolver *clientip.Resolver     // Works out the client address behind trusted proxies
+	verifier   *domainverify.Verifier // Checks the verification token of custom domains
+	wg         sync.WaitGroup         // Background tasks that must finish before the process exits
+
+	analyticsPurged atomic.Int64 // Analytics entries deleted by the retention purger
+
+	janitorRuns    atomic.Int64 // Janitor passes started
+	janitorFailed  atomic.Int64 // Janitor passes that stopped on an error
+	janitorURLs    atomic.Int64 // Expired URLs deleted by the janitor
+	janitorTokens  atomic.Int64 // Expired tokens deleted by the janitor
+	janitorQRCodes atomic.Int64 // Orphaned QR code images deleted by the janitor
+
+	backupRuns   atomic.Int64 // Database backups started
+	backupFailed atomic.Int64 // Database backups that failed
 }
 
 func main() {
 	var cfg config
 
-	// Parsing command line flags
+	// Every setting is a flag; loadConfig also fills them from a config file and CHOPPER_* variables.
 	flag.Int64Var(&cfg.Port, "port", 8080, "Port number")
+	flag.StringVar(&cfg.baseURL, "base-url", "", "Public base URL of short links, e.g. https://chopper.example (taken from each request when empty)")
+	flag.Func("short-domains", "Comma-separated extra short domains every user can create links on", func(s string) error {
+		cfg.shortDomains = nil
+		for _, host := range strings.Split(s, ",") {
+			if host = data.NormalizeHost(host); host != "" {
+				cfg.shortDomains = append(cfg.shortDomains, host)
+			}
+		}
+		return nil
+	})
+	flag.DurationVar(&cfg.domainTimeout, "domain-verify-timeout", 10*time.Second, "How long checking a custom domain's verification token may take")
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file, enables HTTPS together with -tls-key")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file")
+	flag.Int64Var(&cfg.tls.redirectPort, "tls-redirect-port", 0, "Port of a plain HTTP listener redirecting to HTTPS (0 disables it)")
+	flag.DurationVar(&cfg.tls.hstsMaxAge, "hsts-max-age", 365*24*time.Hour, "Strict-Transport-Security max-age sent over HTTPS (0 disables the header)")
+	flag.DurationVar(&cfg.tls.reloadInterval, "tls-reload-interval", time.Minute, "How often the certificate files are checked for changes")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long in-flight requests and background tasks get to finish on shutdown")
+	flag.Func("trusted-proxies", "Comma-separated CIDR ranges of proxies allowed to set X-Forwarded-For", func(s string) error {
+		cfg.trustedProxies = strings.Split(s, ",")
+		return nil
+	})
 	flag.Float64Var(&cfg.rateLimiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.rateLimiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.rateLimiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-	flag.StringVar(&cfg.database.dsn, "dsn", "./database.db", "Path to the SQLite file")
+	flag.StringVar(&cfg.database.backend, "db-backend", "", "Storage backend (sqlite|postgres|memory), derived from the DSN scheme when empty")
+	flag.StringVar(&cfg.database.dsn, "dsn", "./database.db", "Path to the SQLite file or a postgres:// URL")
 	flag.BoolVar(&cfg.dailyLimiter.enabled, "dailylimiter-enabled", true, "Enable daily limiter")
 	flag.Float64Var(&cfg.dailyLimiter.anonymous, "dailyLimiter-ip", 3.0, "Daily limit for Anonymous Users(By IP)")
 	flag.Float64Var(&cfg.dailyLimiter.authenticated, "dailyLimiter-id", 10.0, "Daily limit for Authenticated Users")
-	flag.StringVar(&cfg.database.migrationsPath, "migrations", "./migrations", "Relative Path to the migrations folder")
+	flag.StringVar(&cfg.database.migrationsPath, "migrations", "", "Path to a migrations folder to use instead of the migrations built into the binary")
+	flag.DurationVar(&cfg.database.queryTimeout, "db-query-timeout", 5*time.Second, "Longest a single storage call may take before it is canceled")
+
+	flag.IntVar(&cfg.cache.size, "cache-size", 10000, "Maximum number of short URLs held in the redirect cache (0 disables it)")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "Time a cached short URL stays valid")
+
+	flag.IntVar(&cfg.analytics.queueSize, "analytics-queue", 10000, "Maximum number of clicks buffered before new ones are dropped")
+	flag.IntVar(&cfg.analytics.batchSize, "analytics-batch", 100, "Maximum number of clicks written per transaction")
+	flag.DurationVar(&cfg.analytics.flushInterval, "analytics-flush-interval", time.Second, "Maximum time a click waits before being written")
+	flag.StringVar(&cfg.privacy.ipMode, "privacy-ip-mode", data.IPModeNone, "How client IPs are stored in analytics (none|truncate|hash)")
+	flag.StringVar(&cfg.privacy.ipSalt, "privacy-ip-salt", "", "Secret key used when hashing client IPs")
+	flag.IntVar(&cfg.privacy.retentionDays, "analytics-retention-days", 0, "Days analytics are kept before being deleted (0 keeps them forever)")
+	flag.DurationVar(&cfg.privacy.purgeInterval, "analytics-purge-interval", time.Hour, "How often analytics past their retention window are deleted")
+	flag.DurationVar(&cfg.janitor.interval, "janitor-interval", time.Hour, "How often expired URLs, expired tokens and orphaned QR codes are removed (0 disables)")
+	flag.StringVar(&cfg.backup.dir, "backup-dir", "./backups", "Folder SQLite backups are written to")
+	flag.DurationVar(&cfg.backup.interval, "backup-interval", 24*time.Hour, "How often the SQLite database is backed up (0 disables)")
+	flag.IntVar(&cfg.backup.keep, "backup-keep", 7, "Number of SQLite backups kept, older ones are deleted")
+	flag.StringVar(&cfg.tracing.exporter, "trace-exporter", "none", "Where OpenTelemetry spans are exported (none|stdout|file)")
+	flag.StringVar(&cfg.tracing.file, "trace-file", "./traces.json", "File spans are appended to with -trace-exporter=file")
+	flag.Float64Var(&cfg.tracing.sampleRatio, "trace-sample-ratio", 1, "Share of new traces recorded, between 0 and 1")
+	flag.BoolVar(&cfg.debugVars, "debug-vars", false, "Serve expvar counters on /debug/vars")
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Lowest log level written (debug|info|warn|error)")
+	flag.StringVar(&cfg.log.format, "log-format", "json", "Log line format (json|text)")
+	flag.IntVar(&cfg.readiness.minFreeMB, "readyz-min-free-mb", 100, "Free disk space in MB /readyz requires for the SQLite file and QR codes")
+	flag.BoolVar(&cfg.readiness.smtp, "readyz-smtp", false, "Connect to the SMTP server in /readyz")
+	flag.StringVar(&cfg.geoip.path, "geoip-db", "", "Path to a MaxMind-format (.mmdb) GeoIP database (disabled when empty)")
 
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "6cf67c5b09db70", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "cd351309d5c4c4", "SMTP password")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Chopper <chopper@theakhandpatel.me>", "SMTP sender")
 
-	flag.Parse()
+	err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	err = validateConfig(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if cfg.baseURL != "" {
+		cfg.baseURL = strings.TrimSuffix(cfg.baseURL, "/") + "/"
+	}
+
+	logger := newLogger(os.Stdout, cfg.log.level, cfg.log.format)
+	slog.SetDefault(logger)
 
-	// Initializing the database
-	db, err := openDB(cfg.database.dsn, cfg.database.migrationsPath)
+	shutdownTracing, err := setupTracing(cfg)
 	if err != nil {
-		panic(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
+	defer func() {
+		// Spans still buffered are flushed on the way out.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := shutdownTracing(ctx)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}()
 
-	app := application{
-		Models: data.NewModel(db),
+	// Migration and restore commands run before openModels brings the schema up to date.
+	if command, ok := offlineCommands[flag.Arg(0)]; ok {
+		err = command(cfg, flag.Args()[1:])
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, errUsage) {
+				fmt.Fprintln(os.Stderr, commandUsage)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Initializing the storage backend
+	models, db, err := openModels(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	prom := newPromMetrics()
+
+	app := &application{
+		Models: data.ObserveModel(models, data.WithQueryTimeout(cfg.database.queryTimeout, queryObserver(prom))),
+		db:     db,
 		config: cfg,
+		logger: logger,
+		prom:   prom,
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+
+		verifier: domainverify.New(cfg.domainTimeout),
 	}
 
-	// Starting the server
-	fmt.Println("Initializing server at port:", cfg.Port)
-	err = http.ListenAndServe(fmt.Sprintf(":%v", cfg.Port), app.routes())
-	panic(err)
-}
+	app.ipResolver, err = clientip.NewResolver(cfg.trustedProxies)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-// openDB  initializes the SQLite database.
-func openDB(dsn string, migrationsPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dsn)
+	if cfg.cache.size > 0 {
+		app.urlCache = data.NewCachedURLModel(app.Models.URLS, cfg.cache.size, cfg.cache.ttl)
+		app.Models.URLS = app.urlCache
+	}
+
+	if cfg.geoip.path != "" {
+		app.geo, err = geoip.Open(cfg.geoip.path)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer app.geo.Close()
+	}
+
+	if flag.NArg() > 0 {
+		err = app.runCommand(flag.Args())
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, errUsage) {
+				fmt.Fprintln(os.Stderr, commandUsage)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	app.analytics = newAnalyticsPipeline(app.Models.Analytics, cfg.analytics.queueSize, cfg.analytics.batchSize, cfg.analytics.flushInterval, app.anonymizeBatch, app.logError)
+	app.publishAppVars()
+	app.registerAppGauges()
+
+	err = app.serve()
 	if err != nil {
-		return nil, err
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
-	if err = db.Ping(); err != nil {
-		return nil, err
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then stops accepting
+// requests and drains in-flight work within the configured shutdown timeout.
+// When a certificate is configured it serves HTTPS, optionally alongside a
+// plain HTTP listener that redirects to it.
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%v", app.config.Port),
+		Handler: app.routes(),
 	}
 
-	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	stops := []func(){}
+	var redirectSrv *http.Server
+	if app.tlsEnabled() {
+		reloader, err := certs.NewReloader(app.config.tls.certFile, app.config.tls.keyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		stops = append(stops, app.watchCertificates(reloader))
+
+		if app.config.tls.redirectPort > 0 {
+			redirectSrv = &http.Server{
+				Addr:    fmt.Sprintf(":%v", app.config.tls.redirectPort),
+				Handler: http.HandlerFunc(app.redirectToHTTPS),
+			}
+			go func() {
+				app.logger.Info("redirecting HTTP to HTTPS", "port", app.config.tls.redirectPort)
+				err := redirectSrv.ListenAndServe()
+				if !errors.Is(err, http.ErrServerClosed) {
+					app.logError(err)
+				}
+			}()
+		}
+	}
+
+	stops = append(stops, app.startRetentionPurger(), app.startJanitor(), app.startBackups())
+
+	shutdownError := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		// One deadline covers both the open connections and the background tasks.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		if redirectSrv != nil {
+			redirectSrv.Shutdown(ctx)
+		}
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			srv.Close()
+		}
+
+		// Connections that used up the deadline must not cost the queued clicks
+		// and emails theirs, so the background tasks then get a deadline of their own.
+		drainCtx := ctx
+		if ctx.Err() != nil {
+			var drainCancel context.CancelFunc
+			drainCtx, drainCancel = context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+			defer drainCancel()
+		}
+
+		app.logger.Info("completing background tasks")
+		shutdownError <- errors.Join(err, app.drain(drainCtx, stops...))
+	}()
+
+	// Starting the server
+	var err error
+	if app.tlsEnabled() {
+		app.logger.Info("starting HTTPS server", "port", app.config.Port)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		app.logger.Info("starting server", "port", app.config.Port)
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	app.logger.Info("server stopped")
+	return nil
+}
+
+// openModels builds the data models for the storage backend selected in cfg,
+// along with the database they use, which is nil for the memory backend.
+// The schema of a database is brought up to date first.
+func openModels(cfg config) (data.Model, *sql.DB, error) {
+	backend := cfg.backend()
+	if backend == "memory" {
+		return data.NewMemoryModel(), nil, nil
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://"+migrationsPath,
-		"sqlite3", // Database driver name
-		driver,
-	)
+	db, err := openDB(backend, cfg.database.dsn)
 	if err != nil {
-		panic(err)
+		return data.Model{}, nil, err
 	}
+	err = migrateUp(db, cfg)
+	if err != nil {
+		db.Close()
+		return data.Model{}, nil, err
+	}
+
+	if backend == "postgres" {
+		return data.NewPostgresModel(db), db, nil
+	}
+	return data.NewModel(db), db, nil
+}
+
+// backend returns the storage backend, derived from the DSN when not set explicitly.
+func (cfg config) backend() string {
+	if cfg.database.backend == "" {
+		return backendFromDSN(cfg.database.dsn)
+	}
+	return cfg.database.backend
+}
 
-	// Run all "up" migrations
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		panic(err)
+// backendFromDSN picks the storage backend matching the scheme of dsn.
+func backendFromDSN(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return "postgres"
 	}
-	fmt.Println("Migrations are up....")
-	return db, nil
+	return "sqlite"
 }
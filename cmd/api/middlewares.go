@@ -4,7 +4,6 @@ import (
 	"errors"
 	"expvar"
 	"fmt"
-	"math"
 	"net/http"
 	"runtime/debug"
 	"strconv"
@@ -16,10 +15,20 @@ import (
 
 	"github.com/felixge/httpsnoop"
 	"github.com/go-chi/chi"
-	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
 
+// resolves the client address once per request, trusting forwarding headers only from
+// configured proxies, and rewrites RemoteAddr to it.
+func (app *application) clientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := app.ipResolver.ClientIP(r)
+		r = app.setClientIPInContext(r, ip)
+		r.RemoteAddr = ip
+		next.ServeHTTP(w, r)
+	})
+}
+
 // performs rate limiting on incoming requests.
 func (app *application) rateLimit(next http.HandlerFunc) http.HandlerFunc {
 	type client struct {
@@ -49,7 +58,7 @@ func (app *application) rateLimit(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Only carry out the rate limiting check if it's enabled in the application configuration.
 		if app.config.rateLimiter.enabled {
-			ip := realip.FromRequest(r)
+			ip := app.getClientIPFromContext(r)
 
 			mu.Lock()
 			if _, found := clients[ip]; !found {
@@ -62,6 +71,7 @@ func (app *application) rateLimit(next http.HandlerFunc) http.HandlerFunc {
 			// Check if the client's request rate exceeds the rate limit.
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				app.prom.rateLimited.WithLabelValues("rate").Inc()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -104,7 +114,7 @@ func (app *application) dailyLimiter(next http.HandlerFunc) http.HandlerFunc {
 			user := app.getUserFromContext(r)
 
 			if user.IsAnonymous() {
-				ip := realip.FromRequest(r)
+				ip := app.getClientIPFromContext(r)
 
 				mu.Lock()
 				if _, found := clients[ip]; !found {
@@ -116,6 +126,7 @@ func (app *application) dailyLimiter(next http.HandlerFunc) http.HandlerFunc {
 				// Check if the client's request rate exceeds the rate limit.
 				if !clients[ip].limiter.Allow() {
 					mu.Unlock()
+					app.prom.rateLimited.WithLabelValues("daily").Inc()
 					app.rateLimitExceededResponse(w, r)
 					return
 				}
@@ -140,6 +151,7 @@ func (app *application) dailyLimiter(next http.HandlerFunc) http.HandlerFunc {
 				// Check if the client's request rate exceeds the rate limit.
 				if !clients[userID].limiter.Allow() {
 					mu.Unlock()
+					app.prom.rateLimited.WithLabelValues("daily").Inc()
 					app.rateLimitExceededResponse(w, r)
 					return
 				}
@@ -158,29 +170,51 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		defer func() {
 			if err := recover(); err != nil {
 				w.Header().Set("Connection", "close")
-				fmt.Printf("Stack Trace  %s", debug.Stack())
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				app.requestLogger(r).Error("panic recovered", "panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+				app.errorResponse(w, r, http.StatusInternalServerError, "the server encountered a problem and could not process your request")
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
-// records metrics for api response
-func (app *application) metrics(next http.Handler) http.Handler {
-	totalRequestsRecieved := expvar.NewInt("total_request_recieved")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
-	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status")
-	averageResponseTimeMicroSeconds := expvar.NewInt("average_processing_time_μs")
-	minResponseTimeMicroSeconds := expvar.NewFloat("minimum_processing_time_μs")
-	maxResponseTimeMicroSeconds := expvar.NewFloat("maximum_processing_time_μs")
+// publishAppVars exposes the counters the application keeps through expvar.
+// It may only be called once, as expvar panics on a name published twice.
+func (app *application) publishAppVars() {
+	if app.urlCache != nil {
+		expvar.Publish("url_cache_hits", expvar.Func(func() interface{} { return app.urlCache.Hits() }))
+		expvar.Publish("url_cache_misses", expvar.Func(func() interface{} { return app.urlCache.Misses() }))
+	}
+	expvar.Publish("analytics_enqueued", expvar.Func(func() interface{} { return app.analytics.enqueued.Load() }))
+	expvar.Publish("analytics_dropped", expvar.Func(func() interface{} { return app.analytics.dropped.Load() }))
+	expvar.Publish("analytics_flushed", expvar.Func(func() interface{} { return app.analytics.flushed.Load() }))
+	expvar.Publish("analytics_flush_failed", expvar.Func(func() interface{} { return app.analytics.failed.Load() }))
+	expvar.Publish("analytics_queue_depth", expvar.Func(func() interface{} { return app.analytics.Pending() }))
+	expvar.Publish("analytics_purged", expvar.Func(func() interface{} { return app.analyticsPurged.Load() }))
+	expvar.Publish("janitor_runs", expvar.Func(func() interface{} { return app.janitorRuns.Load() }))
+	expvar.Publish("janitor_failed", expvar.Func(func() interface{} { return app.janitorFailed.Load() }))
+	expvar.Publish("janitor_urls_deleted", expvar.Func(func() interface{} { return app.janitorURLs.Load() }))
+	expvar.Publish("janitor_tokens_deleted", expvar.Func(func() interface{} { return app.janitorTokens.Load() }))
+	expvar.Publish("janitor_qrcodes_deleted", expvar.Func(func() interface{} { return app.janitorQRCodes.Load() }))
+	expvar.Publish("backup_runs", expvar.Func(func() interface{} { return app.backupRuns.Load() }))
+	expvar.Publish("backup_failed", expvar.Func(func() interface{} { return app.backupFailed.Load() }))
+}
 
-	minResponseTimeMicroSeconds.Set(math.MaxFloat64)
+// Request counters published through expvar. They are created once for the
+// process, so that metrics can wrap more than one handler.
+var (
+	totalRequestsRecieved           = expvar.NewInt("total_request_recieved")
+	totalResponsesSent              = expvar.NewInt("total_responses_sent")
+	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+	totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+)
 
+// records metrics for api response
+func (app *application) metrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if r.URL.Path == "/debug/vars" {
+		// Scrapes are left out so they do not drown the traffic they measure.
+		if r.URL.Path == "/debug/vars" || r.URL.Path == "/metrics" {
 			next.ServeHTTP(w, r) // Forward the request directly
 			return
 		}
@@ -188,15 +222,16 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalRequestsRecieved.Add(1)
 		metrics := httpsnoop.CaptureMetrics(next, w, r)
 
-		responseTime := metrics.Duration.Microseconds()
-
 		totalResponsesSent.Add(1)
-		totalProcessingTimeMicroseconds.Add(responseTime)
-		averageResponseTimeMicroSeconds.Set(totalProcessingTimeMicroseconds.Value() / totalResponsesSent.Value())
-		minResponseTimeMicroSeconds.Set(math.Min(float64(minResponseTimeMicroSeconds.Value()), float64(responseTime)))
-		maxResponseTimeMicroSeconds.Set(math.Max(float64(maxResponseTimeMicroSeconds.Value()), float64(responseTime)))
+		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
 
+		// The route pattern keeps the label set bounded, unlike the raw path.
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		app.prom.requestDuration.WithLabelValues(r.Method, route, strconv.Itoa(metrics.Code)).Observe(metrics.Duration.Seconds())
 	})
 }
 
@@ -225,7 +260,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.Models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.Models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -260,7 +295,9 @@ func (app *application) requireAuthorizedUser(next http.HandlerFunc) http.Handle
 			app.badRequestResponse(w, r, errors.New("shortCode is missing"))
 			return
 		}
-		url, err := app.Models.URLS.GetByShort(shortCode)
+		// Codes on other domains are picked with ?domain=, the default domain is used otherwise.
+		domain := data.NormalizeHost(r.URL.Query().Get("domain"))
+		url, err := app.Models.URLS.GetByShort(r.Context(), domain, shortCode)
 		if err != nil {
 			switch {
 
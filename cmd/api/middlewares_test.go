@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsWrapsTwice(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.prom = newPromMetrics()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	first := app.metrics(ok)
+	second := app.metrics(ok)
+
+	before := totalRequestsRecieved.Value()
+	for _, handler := range []http.Handler{first, second} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+	}
+	if got := totalRequestsRecieved.Value() - before; got != 2 {
+		t.Errorf("counted %d requests through two wrapped handlers, want 2", got)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"url_shortner/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	sqlite3 "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// openDB connects to the database of backend and checks it can be reached.
+func openDB(backend string, dsn string) (*sql.DB, error) {
+	driverName := backend
+	if backend == "sqlite" {
+		driverName = "sqlite3"
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrateUp applies the migrations db has not run yet.
+func migrateUp(db *sql.DB, cfg config) error {
+	m, err := newMigrate(db, cfg)
+	if err != nil {
+		return err
+	}
+
+	err = m.Up()
+	var dirty migrate.ErrDirty
+	switch {
+	case errors.As(err, &dirty):
+		return fmt.Errorf("migration %d did not complete; fix its cause, then run the migrate recover command and start again", dirty.Version)
+	case err != nil && !errors.Is(err, migrate.ErrNoChange):
+		return fmt.Errorf("applying migrations: %w", err)
+	}
+
+	slog.Info("migrations are up")
+	return nil
+}
+
+// migrationFiles returns the migration set of the configured backend: the
+// files under -migrations when it is set, or those built into the binary.
+func migrationFiles(cfg config) (fs.FS, error) {
+	dir := "."
+	if cfg.backend() == "postgres" {
+		dir = "postgres"
+	}
+
+	if cfg.database.migrationsPath != "" {
+		return os.DirFS(filepath.Join(cfg.database.migrationsPath, dir)), nil
+	}
+	return fs.Sub(migrations.FS, dir)
+}
+
+// newMigrate returns the migration runner for db, a database of the
+// configured backend.
+func newMigrate(db *sql.DB, cfg config) (*migrate.Migrate, error) {
+	backend := cfg.backend()
+
+	var driver database.Driver
+	var err error
+	switch backend {
+	case "sqlite":
+		driver, err = sqlite3.WithInstance(db, &sqlite3.Config{})
+	case "postgres":
+		driver, err = postgres.WithInstance(db, &postgres.Config{})
+	default:
+		return nil, fmt.Errorf("the %s backend has no schema to migrate", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := migrationFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	source, err := iofs.New(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.NewWithInstance("iofs", source, backend, driver)
+}
+
+// recoverMigration clears the dirty flag a failed migration left behind. Each
+// migration runs in a transaction, so the schema is still at the version
+// before the failed one, which is recorded for `migrate up` to retry from.
+func recoverMigration(m *migrate.Migrate, files fs.FS) error {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if !dirty {
+		return fmt.Errorf("schema version %d is not dirty, there is nothing to recover", version)
+	}
+
+	versions, err := migrationVersions(files)
+	if err != nil {
+		return err
+	}
+	previous := -1
+	for _, v := range versions {
+		if v < version && int(v) > previous {
+			previous = int(v)
+		}
+	}
+	return m.Force(previous)
+}
+
+// latestMigration returns the highest version in a migration set.
+func latestMigration(files fs.FS) (uint, error) {
+	versions, err := migrationVersions(files)
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint
+	for _, v := range versions {
+		if v > latest {
+			latest = v
+		}
+	}
+	return latest, nil
+}
+
+// migrationVersions returns the versions of the up migrations in a migration set.
+func migrationVersions(files fs.FS) ([]uint, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []uint
+	for _, entry := range entries {
+		prefix, _, found := strings.Cut(entry.Name(), "_")
+		if entry.IsDir() || !found || !strings.HasSuffix(entry.Name(), ".up.sql") {
+			continue
+		}
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err == nil {
+			versions = append(versions, uint(version))
+		}
+	}
+	return versions, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newMigrationTestDB opens a fresh SQLite database in a temporary folder and
+// returns it with a configuration pointing at it.
+func newMigrationTestDB(t *testing.T) (*sql.DB, config) {
+	t.Helper()
+
+	var cfg config
+	cfg.database.backend = "sqlite"
+	cfg.database.dsn = filepath.Join(t.TempDir(), "test.db")
+
+	db, err := openDB(cfg.database.backend, cfg.database.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, cfg
+}
+
+// tables returns the names of the tables in db other than the migration bookkeeping.
+func tables(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence') ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestMigrationsUpDownUp(t *testing.T) {
+	db, cfg := newMigrationTestDB(t)
+
+	files, err := migrationFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := latestMigration(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newMigrate(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateUp(db, cfg); err != nil {
+		t.Fatal(err)
+	}
+	version, dirty, err := m.Version()
+	if err != nil || dirty || version != latest {
+		t.Fatalf("got version %d, dirty %t, error %v after migrating up, want version %d", version, dirty, err, latest)
+	}
+	schema := tables(t, db)
+
+	if err := m.Down(); err != nil {
+		t.Fatalf("migrating down: %v", err)
+	}
+	if left := tables(t, db); len(left) != 0 {
+		t.Errorf("tables %v left after migrating all the way down", left)
+	}
+
+	if err := migrateUp(db, cfg); err != nil {
+		t.Fatalf("migrating up again: %v", err)
+	}
+	if again := tables(t, db); strings.Join(again, ",") != strings.Join(schema, ",") {
+		t.Errorf("got tables %v after migrating up again, want %v", again, schema)
+	}
+}
+
+func TestRecoverMigration(t *testing.T) {
+	db, cfg := newMigrationTestDB(t)
+
+	cfg.database.migrationsPath = t.TempDir()
+	for name, content := range map[string]string{
+		"000001_create_things.up.sql":   `CREATE TABLE things (id INTEGER PRIMARY KEY);`,
+		"000001_create_things.down.sql": `DROP TABLE things;`,
+		"000003_add_name.up.sql":        `ALTER TABLE things ADD COLUMN name TEXT; ALTER TABLE missing ADD COLUMN name TEXT;`,
+		"000003_add_name.down.sql":      `ALTER TABLE things DROP COLUMN name;`,
+	} {
+		err := os.WriteFile(filepath.Join(cfg.database.migrationsPath, name), []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := migrationFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newMigrate(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateUp(db, cfg); err == nil {
+		t.Fatal("a failing migration succeeded")
+	}
+	err = migrateUp(db, cfg)
+	if err == nil || !strings.Contains(err.Error(), "migration 3 did not complete") {
+		t.Fatalf("got error %v migrating up a dirty schema, want migration 3 reported", err)
+	}
+
+	if err := recoverMigration(m, files); err != nil {
+		t.Fatal(err)
+	}
+	version, dirty, err := m.Version()
+	if err != nil || dirty || version != 1 {
+		t.Fatalf("got version %d, dirty %t, error %v after recovering, want clean version 1", version, dirty, err)
+	}
+	// The failed migration ran in a transaction, so nothing of it was kept.
+	var columns int
+	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('things') WHERE name = 'name'`).Scan(&columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns != 0 {
+		t.Error("the failed migration left its column behind")
+	}
+
+	if err := recoverMigration(m, files); err == nil {
+		t.Error("recovering a clean schema succeeded")
+	}
+}
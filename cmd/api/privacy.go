@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+	"url_shortner/internal/clientip"
+	"url_shortner/internal/data"
+	"url_shortner/internal/validator"
+)
+
+// validatePrivacyConfig rejects deployment privacy settings that cannot be applied.
+func validatePrivacyConfig(cfg config) error {
+	v := validator.New()
+	data.ValidatePrivacySettings(v, &data.PrivacySettings{IPMode: cfg.privacy.ipMode, RetentionDays: cfg.privacy.retentionDays})
+	v.Check(cfg.privacy.ipMode != "", "ip_mode", "must be provided")
+	v.Check(cfg.privacy.ipMode != data.IPModeHash || cfg.privacy.ipSalt != "", "ip_salt", "must be provided when hashing IP addresses")
+	if !v.Valid() {
+		return fmt.Errorf("invalid privacy configuration: %v", v.Errors)
+	}
+	return nil
+}
+
+// ipModeFor returns the IP mode applying to a user: the stricter of their
+// override and the deployment default, which may have been tightened since
+// the override was saved.
+func (app *application) ipModeFor(settings *data.PrivacySettings) string {
+	mode := app.config.privacy.ipMode
+	if data.IPModeStrength(settings.IPMode) > data.IPModeStrength(mode) {
+		mode = settings.IPMode
+	}
+	// An override can outlive the salt; truncating is then the strictest mode left.
+	if mode == data.IPModeHash && app.config.privacy.ipSalt == "" {
+		mode = data.IPModeTruncate
+	}
+	return mode
+}
+
+// retentionDaysFor returns the retention window applying to a user: the
+// shorter of their override and the deployment default, 0 when neither is set.
+func (app *application) retentionDaysFor(settings *data.PrivacySettings) int {
+	days := app.config.privacy.retentionDays
+	if settings.RetentionDays > 0 && (days == 0 || settings.RetentionDays < days) {
+		days = settings.RetentionDays
+	}
+	return days
+}
+
+// anonymizeIP stores ip in the form mode asks for.
+func (app *application) anonymizeIP(ip string, mode string) string {
+	switch mode {
+	case data.IPModeTruncate:
+		return clientip.Truncate(ip)
+	case data.IPModeHash:
+		return clientip.Hash(ip, app.config.privacy.ipSalt)
+	default:
+		return ip
+	}
+}
+
+// anonymizeBatch applies the privacy settings of each URL owner to the IP
+// addresses of a batch of analytics entries before they are stored.
+func (app *application) anonymizeBatch(ctx context.Context, batch []*data.AnalyticsEntry) {
+	modes := make(map[int64]string)
+	for _, entry := range batch {
+		mode, ok := modes[entry.OwnerID]
+		if !ok {
+			settings, err := app.Models.Privacy.Get(ctx, entry.OwnerID)
+			if err != nil {
+				app.logError(err)
+				settings = &data.PrivacySettings{}
+			}
+			mode = app.ipModeFor(settings)
+			// Never fall back to storing more than the deployment allows.
+			if err != nil && data.IPModeStrength(mode) < data.IPModeStrength(data.IPModeTruncate) {
+				mode = data.IPModeTruncate
+			}
+			modes[entry.OwnerID] = mode
+		}
+		entry.IP = app.anonymizeIP(entry.IP, mode)
+	}
+}
+
+// purgeAnalytics deletes analytics older than the retention window of the user owning them.
+func (app *application) purgeAnalytics(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "analytics.purge")
+	defer func() { endSpan(span, err) }()
+
+	all, err := app.Models.Privacy.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var except []int64
+	for _, settings := range all {
+		// Users whose window is the deployment's are purged with everyone else.
+		days := app.retentionDaysFor(settings)
+		if days <= 0 || days == app.config.privacy.retentionDays {
+			continue
+		}
+		except = append(except, settings.UserID)
+
+		deleted, err := app.Models.Analytics.DeleteOlderThanForUser(ctx, settings.UserID, now.AddDate(0, 0, -days))
+		if err != nil {
+			return err
+		}
+		app.analyticsPurged.Add(deleted)
+	}
+
+	if app.config.privacy.retentionDays > 0 {
+		deleted, err := app.Models.Analytics.DeleteOlderThan(ctx, now.AddDate(0, 0, -app.config.privacy.retentionDays), except)
+		if err != nil {
+			return err
+		}
+		app.analyticsPurged.Add(deleted)
+	}
+
+	return nil
+}
+
+// startRetentionPurger runs purgeAnalytics on the configured interval until the returned function is called.
+func (app *application) startRetentionPurger() (stop func()) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(app.config.privacy.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			err := app.purgeAnalytics(context.Background())
+			if err != nil {
+				app.logError(err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+func (app *application) getPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserFromContext(r)
+
+	settings, err := app.Models.Privacy.Get(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writePrivacy(w, settings)
+}
+
+func (app *application) updatePrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IPMode        string `json:"ip_mode"`
+		RetentionDays int    `json:"retention_days"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getUserFromContext(r)
+	settings := &data.PrivacySettings{
+		UserID:        user.ID,
+		IPMode:        input.IPMode,
+		RetentionDays: input.RetentionDays,
+	}
+
+	v := validator.New()
+	data.ValidatePrivacySettings(v, settings)
+	// Users may tighten the deployment defaults but never loosen them.
+	if settings.IPMode != "" {
+		v.Check(data.IPModeStrength(settings.IPMode) >= data.IPModeStrength(app.config.privacy.ipMode), "ip_mode", "must not store more of the address than the default '"+app.config.privacy.ipMode+"'")
+		v.Check(settings.IPMode != data.IPModeHash || app.config.privacy.ipSalt != "", "ip_mode", "hashing is not available on this deployment")
+	}
+	if app.config.privacy.retentionDays > 0 {
+		v.Check(settings.RetentionDays <= app.config.privacy.retentionDays, "retention_days", fmt.Sprintf("must not be more than the default of %d days", app.config.privacy.retentionDays))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Privacy.Set(r.Context(), settings)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writePrivacy(w, settings)
+}
+
+// writePrivacy responds with a user's overrides and the settings in effect for them.
+func (app *application) writePrivacy(w http.ResponseWriter, settings *data.PrivacySettings) {
+	app.writeJSON(w, http.StatusOK, envelope{
+		"privacy": settings,
+		"effective": envelope{
+			"ip_mode":        app.ipModeFor(settings),
+			"retention_days": app.retentionDaysFor(settings),
+		},
+	})
+}
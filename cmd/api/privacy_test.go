@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+	"url_shortner/internal/data"
+)
+
+func TestIPModeFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		salt     string
+		override string
+		want     string
+	}{
+		{"default applies without override", data.IPModeTruncate, "", "", data.IPModeTruncate},
+		{"stricter override wins", data.IPModeNone, "salt", data.IPModeHash, data.IPModeHash},
+		{"default tightened after override was saved", data.IPModeHash, "salt", data.IPModeTruncate, data.IPModeHash},
+		{"override loosening the default", data.IPModeTruncate, "", data.IPModeNone, data.IPModeTruncate},
+		{"hash override without a salt", data.IPModeNone, "", data.IPModeHash, data.IPModeTruncate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			cfg.privacy.ipMode = tt.fallback
+			cfg.privacy.ipSalt = tt.salt
+			app := newTestApplication(t, cfg)
+
+			got := app.ipModeFor(&data.PrivacySettings{IPMode: tt.override})
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionDaysFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback int
+		override int
+		want     int
+	}{
+		{"neither set", 0, 0, 0},
+		{"default only", 30, 0, 30},
+		{"override only", 0, 7, 7},
+		{"shorter override", 30, 7, 7},
+		{"default shortened after override was saved", 30, 60, 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			cfg.privacy.retentionDays = tt.fallback
+			app := newTestApplication(t, cfg)
+
+			got := app.retentionDaysFor(&data.PrivacySettings{RetentionDays: tt.override})
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPurgeAnalytics(t *testing.T) {
+	// User 1 has no override, 2 a shorter window and 3 one longer than the
+	// deployment's, saved before the deployment window was shortened.
+	overrides := map[int64]int{2: 7, 3: 60}
+	ages := []int{3, 10, 40}
+
+	tests := []struct {
+		name     string
+		fallback int
+		want     map[int64][]int // ages left per user
+	}{
+		{"deployment window", 30, map[int64][]int{1: {3, 10}, 2: {3}, 3: {3, 10}}},
+		{"no deployment window", 0, map[int64][]int{1: {3, 10, 40}, 2: {3}, 3: {3, 10, 40}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			cfg.privacy.retentionDays = tt.fallback
+			app := newTestApplication(t, cfg)
+			ctx := context.Background()
+
+			now := time.Now()
+			urls := make(map[int64]int64)
+			for userID := int64(1); userID <= 3; userID++ {
+				url := &data.URL{ShortCode: "code" + string(rune('0'+userID)), UserID: userID}
+				err := app.Models.URLS.Insert(ctx, url)
+				if err != nil {
+					t.Fatal(err)
+				}
+				urls[userID] = url.ID
+
+				for _, age := range ages {
+					entry := &data.AnalyticsEntry{URLID: url.ID, IP: "192.0.2.1", Timestamp: now.AddDate(0, 0, -age)}
+					err = app.Models.Analytics.Insert(ctx, entry)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				if days, ok := overrides[userID]; ok {
+					err = app.Models.Privacy.Set(ctx, &data.PrivacySettings{UserID: userID, RetentionDays: days})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			err := app.purgeAnalytics(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for userID, want := range tt.want {
+				entries, err := app.Models.Analytics.GetByURLID(ctx, urls[userID])
+				if err != nil {
+					t.Fatal(err)
+				}
+				var left []int
+				for _, entry := range entries {
+					left = append(left, int(now.Sub(entry.Timestamp).Hours()/24+0.5))
+				}
+				sort.Ints(left)
+				if !equalInts(left, want) {
+					t.Errorf("user %d: entries aged %v days left, want %v", userID, left, want)
+				}
+			}
+		})
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"url_shortner/internal/data"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of resolving a short code, used as the outcome label of chopper_redirects_total.
+const (
+	redirectFound    = "found"
+	redirectExpired  = "expired"
+	redirectNotFound = "not_found"
+)
+
+// promMetrics holds the collectors served on /metrics in the Prometheus exposition format.
+type promMetrics struct {
+	registry *prometheus.Registry
+
+	requestDuration *prometheus.HistogramVec
+	redirects       *prometheus.CounterVec
+	rateLimited     *prometheus.CounterVec
+	queryDuration   *prometheus.HistogramVec
+	mailSent        *prometheus.CounterVec
+}
+
+// newPromMetrics registers the application's collectors, along with the Go
+// runtime and process collectors, on a registry of their own.
+func newPromMetrics() *promMetrics {
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chopper_http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests, by route pattern and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chopper_redirects_total",
+			Help: "Short code resolutions, by outcome (found, expired or not_found).",
+		}, []string{"outcome"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chopper_rate_limit_rejections_total",
+			Help: "Requests rejected by a rate limiter, by limiter (rate or daily).",
+		}, []string{"limiter"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chopper_db_query_duration_seconds",
+			Help:    "Time taken by storage calls, by store, operation and outcome.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"store", "operation", "outcome"}),
+		mailSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chopper_mail_sent_total",
+			Help: "Emails handed to the SMTP server, by template and outcome (sent or failed).",
+		}, []string{"template", "outcome"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requestDuration,
+		m.redirects,
+		m.rateLimited,
+		m.queryDuration,
+		m.mailSent,
+	)
+	return m
+}
+
+// observeQuery is the data.Observer recording storage call latency. Missing
+// records are an expected answer, not a failed query.
+func (m *promMetrics) observeQuery(store string, operation string, took time.Duration, err error) {
+	outcome := "ok"
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		outcome = "error"
+	}
+	m.queryDuration.WithLabelValues(store, operation, outcome).Observe(took.Seconds())
+}
+
+// registerAppGauges exposes the counters the application keeps for expvar to Prometheus as well.
+func (app *application) registerAppGauges() {
+	counter := func(name string, help string, value func() int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 { return float64(value()) })
+	}
+
+	app.prom.registry.MustRegister(
+		counter("chopper_analytics_enqueued_total", "Clicks queued for writing.", app.analytics.enqueued.Load),
+		counter("chopper_analytics_dropped_total", "Clicks dropped because the queue was full.", app.analytics.dropped.Load),
+		counter("chopper_analytics_flushed_total", "Clicks written to the database.", app.analytics.flushed.Load),
+		counter("chopper_analytics_flush_failed_total", "Clicks lost to failed batch writes.", app.analytics.failed.Load),
+		counter("chopper_analytics_purged_total", "Analytics entries deleted by the retention purger.", app.analyticsPurged.Load),
+		counter("chopper_janitor_runs_total", "Janitor passes started.", app.janitorRuns.Load),
+		counter("chopper_janitor_failed_total", "Janitor passes that stopped on an error.", app.janitorFailed.Load),
+		counter("chopper_janitor_urls_deleted_total", "Expired URLs deleted by the janitor.", app.janitorURLs.Load),
+		counter("chopper_janitor_tokens_deleted_total", "Expired tokens deleted by the janitor.", app.janitorTokens.Load),
+		counter("chopper_janitor_qrcodes_deleted_total", "Orphaned QR code images deleted by the janitor.", app.janitorQRCodes.Load),
+		counter("chopper_backup_runs_total", "Database backups started.", app.backupRuns.Load),
+		counter("chopper_backup_failed_total", "Database backups that failed.", app.backupFailed.Load),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "chopper_analytics_queue_depth",
+			Help: "Clicks waiting to be written.",
+		}, func() float64 { return float64(app.analytics.Pending()) }),
+	)
+
+	if app.urlCache != nil {
+		app.prom.registry.MustRegister(
+			counter("chopper_url_cache_hits_total", "Short code lookups served from the redirect cache.", app.urlCache.Hits),
+			counter("chopper_url_cache_misses_total", "Short code lookups that went to the database.", app.urlCache.Misses),
+		)
+	}
+}
+
+// metricsHandler serves the registry in the Prometheus exposition format.
+func (app *application) metricsHandler() http.Handler {
+	return promhttp.HandlerFor(app.prom.registry, promhttp.HandlerOpts{})
+}
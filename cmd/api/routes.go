@@ -5,7 +5,6 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
 )
 
@@ -13,6 +12,10 @@ import (
 func (app *application) routes() http.Handler {
 	r := chi.NewRouter()
 
+	r.Use(app.requestID)
+	r.Use(app.trace)
+	r.Use(app.clientIP)
+
 	// Apply middleware for logging and error recovery
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
@@ -23,13 +26,18 @@ func (app *application) routes() http.Handler {
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 	r.Use(app.metrics)
-	r.Use(middleware.Logger)
+	r.Use(app.hsts)
+	r.Use(app.logRequests)
 	r.Use(app.recoverPanic)
 	r.Use(app.authenticate)
 
-	r.Get("/debug/vars", expvar.Handler().ServeHTTP)
+	r.Handle("/metrics", app.metricsHandler())
+	if app.config.debugVars {
+		r.Get("/debug/vars", expvar.Handler().ServeHTTP)
+	}
 
-	r.Get("/", app.HealthCheckHandler)
+	r.Get("/healthz", app.healthzHandler)
+	r.Get("/readyz", app.readyzHandler)
 
 	r.Route("/api/short", func(sr chi.Router) {
 		sr.Get("/", app.requireAuthenticatedUser(app.GetAllShortsHandler))
@@ -40,6 +48,7 @@ func (app *application) routes() http.Handler {
 	})
 
 	r.Get("/api/stats/{shortCode}", app.requirePremiumUser(app.AnalyticsHandler))
+	r.Get("/api/stats/{shortCode}/export", app.requirePremiumUser(app.ExportAnalyticsHandler))
 
 	r.Post("/api/signup", app.registerUserHandler)
 	r.Post("/api/signin", app.loginUserHandler)
@@ -48,10 +57,17 @@ func (app *application) routes() http.Handler {
 	r.Post("/api/changeemail", app.requireAuthenticatedUser(app.changeEmailHandler))
 	r.Post("/api/signout", app.requireAuthenticatedUser(app.logoutUserHandler))
 	r.Post("/api/premium", app.requireAuthenticatedUser(app.registerPremiumHandler))
+	r.Get("/api/privacy", app.requireAuthenticatedUser(app.getPrivacyHandler))
+	r.Put("/api/privacy", app.requireAuthenticatedUser(app.updatePrivacyHandler))
+	r.Get("/api/domains", app.requireAuthenticatedUser(app.listDomainsHandler))
+	r.Post("/api/domains", app.requireAuthenticatedUser(app.createDomainHandler))
+	r.Post("/api/domains/{host}/verify", app.requireAuthenticatedUser(app.verifyDomainHandler))
+	r.Delete("/api/domains/{host}", app.requireAuthenticatedUser(app.deleteDomainHandler))
 
 	r.Get("/qr/{shortCode}", app.rateLimit(app.requirePremiumUser(app.QRCodeHandler)))
 
 	r.Get("/{shortCode}", app.rateLimit(app.ExpandURLHandler))
+	r.Head("/{shortCode}", app.rateLimit(app.ExpandURLHandler))
 
 	return r
 }
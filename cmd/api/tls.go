@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"url_shortner/internal/certs"
+)
+
+// tlsEnabled reports whether the server listens for HTTPS itself.
+func (app *application) tlsEnabled() bool {
+	return app.config.tls.certFile != ""
+}
+
+// deployedURL returns the base URL short links are built on: the configured
+// public base URL, or the scheme and host the request came in on.
+func (app *application) deployedURL(r *http.Request) string {
+	if app.config.baseURL != "" {
+		return app.config.baseURL
+	}
+	return getDeployedURL(r)
+}
+
+// hsts tells browsers to keep using HTTPS for this host. The header is only
+// honoured over HTTPS, so it is left off plain HTTP responses.
+func (app *application) hsts(next http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", int64(app.config.tls.hstsMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && app.config.tls.hstsMaxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectToHTTPS answers plain HTTP requests with a permanent redirect to the
+// same path over HTTPS. Short and verified custom domains keep their host, as
+// their codes only resolve there; other hosts go to the public base URL's host
+// when one is configured.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	domain, _, err := app.domainForHost(r.Context(), host)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.config.Port != 443 {
+		host = net.JoinHostPort(host, strconv.FormatInt(app.config.Port, 10))
+	}
+	if base, err := url.Parse(app.config.baseURL); err == nil && base.Scheme == "https" && domain == "" {
+		host = base.Host
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// watchCertificates reloads the certificate on SIGHUP and whenever its files
+// change on disk, until the returned function is called.
+func (app *application) watchCertificates(reloader *certs.Reloader) (stop func()) {
+	stopWatch := reloader.Watch(app.config.tls.reloadInterval, app.logError)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-hup:
+				err := reloader.Reload()
+				if err != nil {
+					app.logError(fmt.Errorf("reloading TLS certificate: %w", err))
+					continue
+				}
+				app.logger.Info("reloaded TLS certificate")
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(hup)
+		close(quit)
+		<-done
+		stopWatch()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"url_shortner/internal/data"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	var cfg config
+	cfg.Port = 443
+	cfg.baseURL = "https://chopper.example/"
+	cfg.shortDomains = []string{"go.example"}
+	app := newTestApplication(t, cfg)
+
+	ctx := context.Background()
+	claim, err := data.NewDomain(7, "links.customer.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.Models.Domains.Insert(ctx, claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.Models.Domains.MarkVerified(ctx, claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := data.NewDomain(8, "pending.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.Models.Domains.Insert(ctx, pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{"base host", "chopper.example", "https://chopper.example/abc?x=1"},
+		{"short domain", "go.example", "https://go.example/abc?x=1"},
+		{"short domain with port", "go.example:80", "https://go.example/abc?x=1"},
+		{"verified custom domain", "links.customer.example", "https://links.customer.example/abc?x=1"},
+		{"unverified custom domain", "pending.example", "https://chopper.example/abc?x=1"},
+		{"unknown host", "10.0.0.1", "https://chopper.example/abc?x=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/abc?x=1", nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+
+			app.redirectToHTTPS(w, r)
+
+			if w.Code != http.StatusPermanentRedirect {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusPermanentRedirect)
+			}
+			if got := w.Header().Get("Location"); got != tt.want {
+				t.Errorf("redirected to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
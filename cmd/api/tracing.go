@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+	"url_shortner/internal/data"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the application's spans. It goes through the global provider,
+// which is a no-op until setupTracing installs an exporter.
+var tracer = otel.Tracer("url_shortner/cmd/api")
+
+// setupTracing installs the trace exporter picked in cfg and returns a function
+// flushing the spans still buffered. With no exporter, spans are not recorded.
+func setupTracing(cfg config) (shutdown func(ctx context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var out io.Writer
+	switch cfg.tracing.exporter {
+	case "stdout":
+		out = os.Stdout
+	case "file":
+		file, err := os.OpenFile(cfg.tracing.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening trace file: %w", err)
+		}
+		out = file
+	default:
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.tracing.sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("chopper"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file, ok := out.(*os.File); ok && file != os.Stdout {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// trace starts a server span per request, continuing the trace of an incoming
+// traceparent header. The span is renamed after the chi route pattern once the
+// router has matched it.
+func (app *application) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		span.SetAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path))
+		if info := app.getRequestInfoFromContext(r); info != nil {
+			span.SetAttributes(attribute.String("request.id", info.id))
+		}
+
+		r = r.WithContext(ctx)
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+		if info := app.getRequestInfoFromContext(r); info != nil && info.userID != 0 {
+			span.SetAttributes(attribute.Int64("user.id", info.userID))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(metrics.Code))
+		if metrics.Code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(metrics.Code))
+		}
+	})
+}
+
+// queryObserver returns the data.Observer wrapping each storage call in a
+// client span and timing it for /metrics.
+func queryObserver(prom *promMetrics) data.Observer {
+	return func(ctx context.Context, store string, operation string) (context.Context, func(err error)) {
+		ctx, span := tracer.Start(ctx, store+"."+operation, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("db.store", store), attribute.String("db.operation", operation)))
+		start := time.Now()
+
+		return ctx, func(err error) {
+			prom.observeQuery(store, operation, time.Since(start), err)
+			// A missing record is an answer, not a failed query.
+			if errors.Is(err, data.ErrRecordNotFound) {
+				err = nil
+			}
+			endSpan(span, err)
+		}
+	}
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,227 @@
+// Package backup takes online copies of a SQLite database and restores them.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	filePrefix = "chopper-"
+	fileExt    = ".db"
+	timeFormat = "20060102T150405.000000Z"
+
+	// legacyTimeFormat named backups to the second, before two taken in the
+	// same second could overwrite each other.
+	legacyTimeFormat = "20060102T150405Z"
+)
+
+// ErrNoBackup is returned by Latest when the directory holds no backup.
+var ErrNoBackup = errors.New("no backup found")
+
+// Backup is a copy of the database taken at Time.
+type Backup struct {
+	Path string
+	Time time.Time
+}
+
+// Create writes a consistent copy of the SQLite database behind db into dir
+// with VACUUM INTO, then deletes all but the keep newest backups. The copy is
+// read in one transaction: readers carry on, but with the default rollback
+// journal writes wait for it to finish, so only a WAL database takes writes
+// during a backup. The copy only gets its final name once complete, and
+// never takes the name of an existing backup, so an interrupted or concurrent
+// backup never replaces a good one.
+func Create(ctx context.Context, db *sql.DB, dir string, keep int) (Backup, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	now := time.Now().UTC()
+	b := Backup{
+		Path: filepath.Join(dir, filePrefix+now.Format(timeFormat)+fileExt),
+		Time: now.Truncate(time.Microsecond),
+	}
+	tmp := b.Path + ".tmp"
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+
+	_, err = db.ExecContext(ctx, `VACUUM INTO ?`, tmp)
+	if err != nil {
+		return Backup{}, fmt.Errorf("writing backup: %w", err)
+	}
+	// Unlike a rename, a link fails when the name is taken.
+	err = os.Link(tmp, b.Path)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	return b, rotate(dir, keep)
+}
+
+// rotate deletes the backups in dir past the keep newest.
+func rotate(dir string, keep int) error {
+	backups, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		err = os.Remove(backups[i].Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the backups in dir, newest first. A missing directory holds none.
+func List(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileExt)
+		taken, err := time.Parse(timeFormat, stamp)
+		if err != nil {
+			taken, err = time.Parse(legacyTimeFormat, stamp)
+		}
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(dir, name), Time: taken})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// Latest returns the newest backup in dir, or ErrNoBackup.
+func Latest(dir string) (Backup, error) {
+	backups, err := List(dir)
+	if err != nil {
+		return Backup{}, err
+	}
+	if len(backups) == 0 {
+		return Backup{}, ErrNoBackup
+	}
+	return backups[0], nil
+}
+
+// Restore replaces the SQLite file at dbPath with the backup at src, once the
+// backup passes SQLite's integrity check and check, which is given the backup
+// opened read-only. The database it replaces is moved aside, along with its
+// journal files, and its new path is returned. When the swap fails part way,
+// the files moved aside are put back. Nothing may have dbPath open.
+func Restore(src string, dbPath string, check func(db *sql.DB) error) (previous string, err error) {
+	err = verify(src, check)
+	if err != nil {
+		return "", err
+	}
+
+	// The copy is written next to the database, so the final rename cannot cross file systems.
+	tmp := dbPath + ".restoring"
+	defer os.Remove(tmp)
+	err = copyFile(src, tmp)
+	if err != nil {
+		return "", err
+	}
+
+	previous = dbPath + ".before-restore-" + time.Now().UTC().Format(timeFormat)
+	var moved []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(moved) - 1; i >= 0; i-- {
+			if undoErr := os.Rename(previous+moved[i], dbPath+moved[i]); undoErr != nil {
+				err = fmt.Errorf("%w; moving %s back also failed: %v", err, previous+moved[i], undoErr)
+			}
+		}
+	}()
+
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		err = os.Rename(dbPath+suffix, previous+suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		moved = append(moved, suffix)
+	}
+
+	err = os.Rename(tmp, dbPath)
+	if err != nil {
+		return "", err
+	}
+	if len(moved) == 0 || moved[0] != "" {
+		previous = ""
+	}
+	return previous, nil
+}
+
+// verify opens the backup at path read-only and runs the integrity check and check on it.
+func verify(path string, check func(db *sql.DB) error) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	err = db.QueryRow(`PRAGMA integrity_check`).Scan(&result)
+	if err != nil {
+		return fmt.Errorf("reading backup: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup is corrupt: %s", result)
+	}
+
+	return check(db)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
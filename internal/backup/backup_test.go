@@ -0,0 +1,216 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestDB creates a SQLite database at path holding a single note.
+func newTestDB(t *testing.T, path string, note string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE notes (body TEXT); INSERT INTO notes (body) VALUES (?)`, note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// readNote returns the note kept in the SQLite database at path.
+func readNote(t *testing.T, path string) string {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var note string
+	err = db.QueryRow(`SELECT body FROM notes`).Scan(&note)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return note
+}
+
+func acceptAll(db *sql.DB) error { return nil }
+
+func TestCreateRotates(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestDB(t, filepath.Join(t.TempDir(), "chopper.db"), "hello")
+
+	// A backup named the way older releases did is the oldest one, and pruned first.
+	legacy := filepath.Join(dir, filePrefix+"20200101T000000Z"+fileExt)
+	err := os.WriteFile(legacy, nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const keep = 3
+	var created []Backup
+	for i := 0; i < keep+2; i++ {
+		b, err := Create(context.Background(), db, dir, keep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, b)
+	}
+
+	backups, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != keep {
+		t.Fatalf("got %d backups, want %d", len(backups), keep)
+	}
+	for i, b := range backups {
+		want := created[len(created)-1-i]
+		if b.Path != want.Path || !b.Time.Equal(want.Time) {
+			t.Errorf("backup %d is %s taken at %s, want %s taken at %s", i, b.Path, b.Time, want.Path, want.Time)
+		}
+	}
+	if note := readNote(t, backups[0].Path); note != "hello" {
+		t.Errorf("the backup holds %q, want %q", note, "hello")
+	}
+
+	latest, err := Latest(dir)
+	if err != nil || latest.Path != created[len(created)-1].Path {
+		t.Errorf("got latest backup %s, %v, want %s", latest.Path, err, created[len(created)-1].Path)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != keep {
+		t.Errorf("got %d files in the backup folder, want only the %d backups", len(entries), keep)
+	}
+}
+
+func TestLatestWithoutBackups(t *testing.T) {
+	_, err := Latest(filepath.Join(t.TempDir(), "missing"))
+	if !errors.Is(err, ErrNoBackup) {
+		t.Errorf("got %v, want ErrNoBackup", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "chopper.db")
+	newTestDB(t, dbPath, "current").Close()
+	err := os.WriteFile(dbPath+"-wal", []byte("wal"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(t.TempDir(), "backup.db")
+	newTestDB(t, src, "restored").Close()
+
+	previous, err := Restore(src, dbPath, acceptAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(previous + "-wal"); err != nil {
+		t.Errorf("the replaced journal was not moved along: %v", err)
+	}
+	if _, err := os.Stat(dbPath + "-wal"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the old journal is still next to the restored database: %v", err)
+	}
+	if note := readNote(t, dbPath); note != "restored" {
+		t.Errorf("the database holds %q after restoring, want %q", note, "restored")
+	}
+	if note := readNote(t, previous); note != "current" {
+		t.Errorf("the replaced database holds %q, want %q", note, "current")
+	}
+}
+
+func TestRestoreRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		backup  func(t *testing.T, path string)
+		check   func(db *sql.DB) error
+		wantErr string
+	}{
+		{
+			name: "corrupt backup",
+			backup: func(t *testing.T, path string) {
+				err := os.WriteFile(path, []byte(strings.Repeat("not a database ", 512)), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			check:   acceptAll,
+			wantErr: "reading backup",
+		},
+		{
+			name: "backup from a newer release",
+			backup: func(t *testing.T, path string) {
+				newTestDB(t, path, "from the future").Close()
+			},
+			check: func(db *sql.DB) error {
+				return errors.New("the backup is at schema version 99, newer than the 20 this release knows")
+			},
+			wantErr: "schema version 99",
+		},
+		{
+			name:    "missing backup",
+			backup:  func(t *testing.T, path string) {},
+			check:   acceptAll,
+			wantErr: "no such file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dbPath := filepath.Join(dir, "chopper.db")
+			newTestDB(t, dbPath, "current").Close()
+			current, err := os.ReadFile(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]string{"": string(current), "-wal": "wal", "-shm": "shm"}
+			for suffix, content := range files {
+				err := os.WriteFile(dbPath+suffix, []byte(content), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			src := filepath.Join(t.TempDir(), "backup.db")
+			tt.backup(t, src)
+
+			_, err = Restore(src, dbPath, tt.check)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+
+			for suffix, content := range files {
+				got, err := os.ReadFile(dbPath + suffix)
+				if err != nil || string(got) != content {
+					t.Errorf("chopper.db%s changed after a failed restore (error %v)", suffix, err)
+				}
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(files) {
+				t.Errorf("got %d files next to the database after a failed restore, want %d", len(entries), len(files))
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package clientip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver works out the address of the client behind a request. Forwarding
+// headers are only honoured when the request arrives from a trusted proxy, as
+// any client can set them.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver returns a Resolver trusting the given proxies, each written as a CIDR range or a single address.
+func NewResolver(proxies []string) (*Resolver, error) {
+	res := &Resolver{}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			if ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		res.trusted = append(res.trusted, network)
+	}
+	return res, nil
+}
+
+// ClientIP returns the normalised address of the client that sent r.
+//
+// The connection's peer address is used unless it belongs to a trusted proxy.
+// In that case X-Forwarded-For is walked from right to left and the first
+// address not belonging to a trusted proxy wins, falling back to X-Real-IP.
+func (res *Resolver) ClientIP(r *http.Request) string {
+	peer := Normalize(r.RemoteAddr)
+	if !res.isTrusted(peer) {
+		return peer
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := Normalize(hops[i])
+		if hop == "" {
+			continue
+		}
+		client = hop
+		if !res.isTrusted(hop) {
+			return hop
+		}
+	}
+	if client != "" {
+		return client
+	}
+
+	if realIP := Normalize(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return peer
+}
+
+func (res *Resolver) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range res.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize strips any port and IPv6 zone from addr and returns the canonical
+// form of the address, with IPv4-mapped IPv6 addresses written as IPv4.
+// Values that are not IP addresses yield an empty string.
+func Normalize(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.String()
+}
+
+// Truncate zeroes the host part of ip: the last octet of an IPv4 address, or
+// everything past the first 48 bits of an IPv6 address. Values that are not IP
+// addresses yield an empty string.
+func Truncate(ip string) string {
+	addr := net.ParseIP(Normalize(ip))
+	if addr == nil {
+		return ""
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return addr.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// Hash returns a keyed hash of ip, letting unique visitors be counted without
+// storing their address.
+func Hash(ip string, salt string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(Normalize(ip)))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
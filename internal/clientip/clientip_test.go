@@ -0,0 +1,192 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.10", "192.0.2.10"},
+		{"192.0.2.10:54321", "192.0.2.10"},
+		{" 192.0.2.10 ", "192.0.2.10"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"[fe80::1%eth0]:8080", "fe80::1"},
+		{"::ffff:192.0.2.10", "192.0.2.10"},
+		{"2001:DB8:0:0::1", "2001:db8::1"},
+		{"not an address", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Normalize(tt.addr); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.123", "192.0.2.0"},
+		{"192.0.2.123:8080", "192.0.2.0"},
+		{"::ffff:198.51.100.7", "198.51.100.0"},
+		{"2001:db8:abcd:1234:5678::1", "2001:db8:abcd::"},
+		{"[2001:db8:abcd:ffff::1]:443", "2001:db8:abcd::"},
+		{"fe80::aaaa:1%eth0", "fe80::"},
+		{"garbage", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Truncate(tt.ip); got != tt.want {
+			t.Errorf("Truncate(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	first := Hash("192.0.2.1", "salt")
+
+	if len(first) != 32 {
+		t.Errorf("got a hash of %d characters, want 32", len(first))
+	}
+	if first == "192.0.2.1" {
+		t.Error("the address was stored as it is")
+	}
+	if again := Hash("192.0.2.1", "salt"); again != first {
+		t.Errorf("hashing twice with the same salt gave %q and %q", first, again)
+	}
+	// The same address seen through a port or as IPv4-mapped IPv6 is one visitor.
+	if other := Hash("[::ffff:192.0.2.1]:443", "salt"); other != first {
+		t.Errorf("hashing a differently written address gave %q, want %q", other, first)
+	}
+	if other := Hash("192.0.2.1", "pepper"); other == first {
+		t.Error("hashing with another salt gave the same hash")
+	}
+	if other := Hash("192.0.2.2", "salt"); other == first {
+		t.Error("hashing another address gave the same hash")
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	tests := []struct {
+		proxies []string
+		wantErr bool
+	}{
+		{[]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::1", " ", ""}, false},
+		{[]string{"10.0.0.0/33"}, true},
+		{[]string{"10.0.0.256"}, true},
+		{[]string{"proxy.internal"}, true},
+		{[]string{"10.0.0.0/8", "not/a/cidr"}, true},
+	}
+
+	for _, tt := range tests {
+		_, err := NewResolver(tt.proxies)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewResolver(%q): got error %v, want error %t", tt.proxies, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	res, err := NewResolver([]string{"10.0.0.0/8", "2001:db8:ffff::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{
+			name: "direct client",
+			peer: "198.51.100.7:5000",
+			want: "198.51.100.7",
+		},
+		{
+			name:      "untrusted peer spoofing X-Forwarded-For",
+			peer:      "198.51.100.7:5000",
+			forwarded: []string{"203.0.113.9"},
+			want:      "198.51.100.7",
+		},
+		{
+			name:   "untrusted peer spoofing X-Real-IP",
+			peer:   "198.51.100.7:5000",
+			realIP: "203.0.113.9",
+			want:   "198.51.100.7",
+		},
+		{
+			name:      "trusted proxy",
+			peer:      "10.0.0.2:5000",
+			forwarded: []string{"203.0.113.9"},
+			want:      "203.0.113.9",
+		},
+		{
+			name:      "rightmost untrusted hop wins over a spoofed leftmost one",
+			peer:      "10.0.0.2:5000",
+			forwarded: []string{"192.0.2.66, 203.0.113.9, 10.1.2.3"},
+			want:      "203.0.113.9",
+		},
+		{
+			name:      "hops split across headers",
+			peer:      "10.0.0.2:5000",
+			forwarded: []string{"192.0.2.66, 203.0.113.9", "10.1.2.3"},
+			want:      "203.0.113.9",
+		},
+		{
+			name:      "garbage hops are skipped",
+			peer:      "10.0.0.2:5000",
+			forwarded: []string{"203.0.113.9, unknown, "},
+			want:      "203.0.113.9",
+		},
+		{
+			name:      "every hop trusted",
+			peer:      "10.0.0.2:5000",
+			forwarded: []string{"10.9.9.9, 10.1.2.3"},
+			want:      "10.9.9.9",
+		},
+		{
+			name:   "trusted proxy setting X-Real-IP only",
+			peer:   "10.0.0.2:5000",
+			realIP: "203.0.113.9",
+			want:   "203.0.113.9",
+		},
+		{
+			name: "trusted proxy without headers",
+			peer: "10.0.0.2:5000",
+			want: "10.0.0.2",
+		},
+		{
+			name:      "trusted IPv6 proxy",
+			peer:      "[2001:db8:ffff::1]:443",
+			forwarded: []string{"[2001:db8:1::5]:1234"},
+			want:      "2001:db8:1::5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := res.ClientIP(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"net/http"
 	"time"
 	"url_shortner/internal/utils"
-
-	"github.com/mattn/go-sqlite3"
 )
 
 // ErrRecordNotFound is returned when a record is not found in the database.
@@ -19,11 +18,16 @@ var ErrMaxCollision = errors.New("collision limit exceeded")
 // ErrDuplicateEntry is returned when a duplicate entry already exists in the database.
 var ErrDuplicateEntry = errors.New("entry already exists")
 
+// ReservedShortCodes are paths the server answers itself, which would shadow a
+// short code of the same name on every domain.
+var ReservedShortCodes = []string{"healthz", "metrics", "readyz"}
+
 // URL represents a shortened URL record.
 type URL struct {
 	ID        int64 `json:"-"`
 	LongForm  string
 	ShortCode string
+	Domain    string `json:"domain"` // Short domain the code lives on, empty for the deployment's default domain
 	Redirect  int
 	UserID    int64     `json:"-"`
 	Created   time.Time `json:"-"`
@@ -31,8 +35,8 @@ type URL struct {
 	Once      bool `json:"once"` // Once is used to specify that this short url will be deleted as soon as it is used once.
 }
 
-// NewURL creates a new URL instance with a shortened version of the provided long URL.
-func NewURL(longURL string, shortCode string, redirect int, user *User, once bool) *URL {
+// NewURL creates a new URL instance on domain with a shortened version of the provided long URL.
+func NewURL(domain string, longURL string, shortCode string, redirect int, user *User, once bool) *URL {
 	if shortCode == "" {
 		if user.IsAnonymous() {
 			shortCode = utils.GetShortCode(8)
@@ -55,6 +59,7 @@ func NewURL(longURL string, shortCode string, redirect int, user *User, once boo
 	return &URL{
 		LongForm:  longURL,
 		ShortCode: shortCode,
+		Domain:    domain,
 		Redirect:  redirect,
 		Created:   time.Now(),
 		Expired:   expiry,
@@ -72,29 +77,21 @@ func (url *URL) Reshorten() {
 	}
 }
 
-// URLModel represents the database model for URL operations.
+// URLModel is the SQLite implementation of URLStore.
 type URLModel struct {
 	DB *sql.DB
 }
 
 // Insert inserts a new URL record into the database.
-func (model *URLModel) Insert(url *URL) error {
+func (model *URLModel) Insert(ctx context.Context, url *URL) error {
 	query := `
-		INSERT INTO urls (long_url, short_url, redirect, user_id, created, expired, once) VALUES (?,?,?,?,?,?,?);
+		INSERT INTO urls (long_url, short_url, domain, redirect, user_id, created, expired, once) VALUES (?,?,?,?,?,?,?,?);
 	`
 
-	res, err := model.DB.Exec(query, url.LongForm, url.ShortCode, url.Redirect, url.UserID, url.Created, url.Expired, url.Once)
+	res, err := model.DB.ExecContext(ctx, query, url.LongForm, url.ShortCode, url.Domain, url.Redirect, url.UserID, url.Created, url.Expired, url.Once)
 
 	if err != nil {
-		// Check for duplicate entry error
-		sqliteErr, isSQLError := err.(sqlite3.Error)
-		if isSQLError && sqliteErr.Code == sqlite3.ErrConstraint {
-			return ErrDuplicateEntry
-		}
-		if errors.Is(err, sqlite3.ErrConstraint) {
-			return ErrDuplicateEntry
-		}
-		return err
+		return sqliteError(err, ErrDuplicateEntry)
 	}
 
 	id, err := res.LastInsertId()
@@ -106,15 +103,16 @@ func (model *URLModel) Insert(url *URL) error {
 	return nil
 }
 
-// GetByShort retrieves a URL record based on the short URL.
-func (model *URLModel) GetByShort(shortCode string) (*URL, error) {
+// GetByShort retrieves a URL record based on its domain and short code.
+func (model *URLModel) GetByShort(ctx context.Context, domain string, shortCode string) (*URL, error) {
 	query := `
-		SELECT id, long_url,  redirect, user_id, created, expired, once FROM urls WHERE short_url = ?;
+		SELECT id, long_url,  redirect, user_id, created, expired, once FROM urls WHERE domain = ? AND short_url = ?;
 	`
-	row := model.DB.QueryRow(query, shortCode)
+	row := model.DB.QueryRowContext(ctx, query, domain, shortCode)
 
 	url := &URL{
 		ShortCode: shortCode,
+		Domain:    domain,
 	}
 	err := row.Scan(&url.ID, &url.LongForm, &url.Redirect, &url.UserID, &url.Created, &url.Expired, &url.Once)
 	if err != nil {
@@ -128,11 +126,11 @@ func (model *URLModel) GetByShort(shortCode string) (*URL, error) {
 }
 
 // GetAllForUser retrieves all urls by the user.
-func (model *URLModel) GetAllForUser(userID int64) ([]*URL, error) {
+func (model *URLModel) GetAllForUser(ctx context.Context, userID int64) ([]*URL, error) {
 	query := `
-		SELECT id, long_url, short_url,  redirect, user_id, created, expired, once FROM urls WHERE user_id = ?;
+		SELECT id, long_url, short_url, domain, redirect, user_id, created, expired, once FROM urls WHERE user_id = ?;
 	`
-	rows, err := model.DB.Query(query, userID)
+	rows, err := model.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +140,7 @@ func (model *URLModel) GetAllForUser(userID int64) ([]*URL, error) {
 
 	for rows.Next() {
 		var url URL
-		err := rows.Scan(&url.ID, &url.LongForm, &url.ShortCode, &url.Redirect, &url.UserID, &url.Created, &url.Expired, &url.Once)
+		err := rows.Scan(&url.ID, &url.LongForm, &url.ShortCode, &url.Domain, &url.Redirect, &url.UserID, &url.Created, &url.Expired, &url.Once)
 		if err != nil {
 			return nil, err
 		}
@@ -152,17 +150,18 @@ func (model *URLModel) GetAllForUser(userID int64) ([]*URL, error) {
 	return urls, nil
 }
 
-func (model *URLModel) DeleteByShort(shortCode string) error {
-	tx, err := model.DB.Begin()
+// DeleteByShort deletes the URL with the given domain and short code.
+func (model *URLModel) DeleteByShort(ctx context.Context, domain string, shortCode string) error {
+	tx, err := model.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback() // Rollback the transaction
 
 	query := `
-		DELETE FROM urls WHERE short_url = ?;
+		DELETE FROM urls WHERE domain = ? AND short_url = ?;
 	`
-	_, err = tx.Exec(query, shortCode)
+	_, err = tx.ExecContext(ctx, query, domain, shortCode)
 	if err != nil {
 		return err
 	}
@@ -176,15 +175,99 @@ func (model *URLModel) DeleteByShort(shortCode string) error {
 	return nil
 }
 
+// DeleteExpired deletes the URLs that expired before cutoff, together with their
+// analytics, in a single transaction and returns the URLs it deleted.
+func (model *URLModel) DeleteExpired(ctx context.Context, cutoff time.Time) ([]*URL, error) {
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	expired := `SELECT id FROM urls WHERE CAST(strftime('%s', expired) AS INTEGER) < ?`
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, short_url, domain, user_id FROM urls WHERE id IN (`+expired+`);`, cutoff.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []*URL
+	for rows.Next() {
+		var url URL
+		err := rows.Scan(&url.ID, &url.ShortCode, &url.Domain, &url.UserID)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, &url)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM urls WHERE id IN (`+expired+`);`, cutoff.Unix())
+	if err != nil {
+		return nil, err
+	}
+	// Foreign keys are not enforced, so this also sweeps up the analytics of
+	// URLs removed earlier through DeleteByShort.
+	_, err = tx.ExecContext(ctx, `DELETE FROM analytics WHERE url_id NOT IN (SELECT id FROM urls);`)
+	if err != nil {
+		return nil, err
+	}
+
+	return urls, tx.Commit()
+}
+
+// DeleteByDomain deletes every URL on domain, together with their analytics, in
+// a single transaction and returns the URLs it deleted.
+func (model *URLModel) DeleteByDomain(ctx context.Context, domain string) ([]*URL, error) {
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, short_url, domain, user_id FROM urls WHERE domain = ?;`, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []*URL
+	for rows.Next() {
+		var url URL
+		err := rows.Scan(&url.ID, &url.ShortCode, &url.Domain, &url.UserID)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, &url)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM analytics WHERE url_id IN (SELECT id FROM urls WHERE domain = ?);`, domain)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM urls WHERE domain = ?;`, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return urls, tx.Commit()
+}
+
 // Update modifies an existing URL record in the database.
-func (model *URLModel) Update(url *URL) error {
+func (model *URLModel) Update(ctx context.Context, url *URL) error {
 	query := `
 		UPDATE urls
 		SET long_url = ?, short_url = ?, redirect = ?, user_id = ?, expired = ?, once = ?
 		WHERE id = ?;
 	`
 
-	_, err := model.DB.Exec(query, url.LongForm, url.ShortCode, url.Redirect, url.UserID, url.Expired, url.Once, url.ID)
+	_, err := model.DB.ExecContext(ctx, query, url.LongForm, url.ShortCode, url.Redirect, url.UserID, url.Expired, url.Once, url.ID)
 	if err != nil {
 		return err
 	}
@@ -192,15 +275,16 @@ func (model *URLModel) Update(url *URL) error {
 	return nil
 }
 
-// GetByLongURL retrieves a URL record based on the long URL.
-func (model *URLModel) GetByLongURL(longURL string, redirectType int, userID int64) (*URL, error) {
+// GetByLongURL retrieves a URL record on domain based on the long URL.
+func (model *URLModel) GetByLongURL(ctx context.Context, domain string, longURL string, redirectType int, userID int64) (*URL, error) {
 	query := `
-		SELECT id, long_url, short_url, redirect, created, expired, once FROM urls WHERE long_url = ? AND redirect=? AND user_id = ?;
+		SELECT id, long_url, short_url, redirect, created, expired, once FROM urls WHERE domain = ? AND long_url = ? AND redirect=? AND user_id = ?;
 	`
-	row := model.DB.QueryRow(query, longURL, redirectType, userID)
+	row := model.DB.QueryRowContext(ctx, query, domain, longURL, redirectType, userID)
 
 	url := &URL{
 		UserID: userID,
+		Domain: domain,
 	}
 	err := row.Scan(&url.ID, &url.LongForm, &url.ShortCode, &url.Redirect, &url.Created, &url.Expired, &url.Once)
 	if err != nil {
@@ -1,8 +1,14 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
+	"url_shortner/internal/geoip"
+	"url_shortner/internal/useragent"
+	"url_shortner/internal/validator"
 )
 
 // AnalyticsEntry represents a single entry of analytics data.
@@ -13,31 +19,160 @@ type AnalyticsEntry struct {
 	UserAgent string    `json:"user-agent"`
 	Referrer  string    `json:"referrer"`
 	Timestamp time.Time `json:"accessed_at"`
+
+	// Parsed from UserAgent when the click is recorded.
+	Browser        string `json:"browser"`
+	BrowserVersion string `json:"browser_version"`
+	OS             string `json:"os"`
+	Device         string `json:"device"`
+
+	// Resolved from IP when a GeoIP database is configured.
+	Country string `json:"country"`
+	Region  string `json:"region"`
+	City    string `json:"city"`
+
+	// Bot is set for clicks from crawlers, link-preview fetchers and HEAD requests.
+	Bot bool `json:"bot"`
+
+	// OwnerID is the user owning the URL. It picks the privacy settings applied
+	// before the entry is stored and is not persisted itself.
+	OwnerID int64 `json:"-"`
+}
+
+// ParseUserAgent fills the browser, OS and device fields from UserAgent.
+func (entry *AnalyticsEntry) ParseUserAgent() {
+	agent := useragent.Parse(entry.UserAgent)
+	entry.Browser = agent.Browser
+	entry.BrowserVersion = agent.BrowserVersion
+	entry.OS = agent.OS
+	entry.Device = agent.Device
 }
 
-// AnalyticsModel provides methods to interact with the analytics data in the database.
+// SetLocation fills the country, region and city fields.
+func (entry *AnalyticsEntry) SetLocation(location geoip.Location) {
+	entry.Country = location.Country
+	entry.Region = location.Region
+	entry.City = location.City
+}
+
+// AnalyticsModel is the SQLite implementation of AnalyticsStore.
 type AnalyticsModel struct {
 	DB *sql.DB
 }
 
+const sqliteInsertAnalytics = `
+			INSERT INTO analytics (url_id, ip, user_agent, referrer, timestamp, browser, browser_version, os, device, country, region, city, bot)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+`
+
+// insertArgs returns the values for the columns listed in the analytics INSERT statements.
+func (entry *AnalyticsEntry) insertArgs() []interface{} {
+	return []interface{}{entry.URLID, entry.IP, entry.UserAgent, entry.Referrer, entry.Timestamp,
+		entry.Browser, entry.BrowserVersion, entry.OS, entry.Device, entry.Country, entry.Region, entry.City, entry.Bot}
+}
+
 // Insert adds a new analytics entry into the database.
-func (model *AnalyticsModel) Insert(entry *AnalyticsEntry) error {
-	query := `
-			INSERT INTO analytics (url_id, ip, user_agent, referrer, timestamp)
-			VALUES (?, ?, ?, ?, ?);
-	`
-	_, err := model.DB.Exec(query, entry.URLID, entry.IP, entry.UserAgent, entry.Referrer, entry.Timestamp)
+func (model *AnalyticsModel) Insert(ctx context.Context, entry *AnalyticsEntry) error {
+	_, err := model.DB.ExecContext(ctx, sqliteInsertAnalytics, entry.insertArgs()...)
 	return err
 }
 
+// InsertBatch adds several analytics entries in a single transaction.
+func (model *AnalyticsModel) InsertBatch(ctx context.Context, entries []*AnalyticsEntry) error {
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, sqliteInsertAnalytics)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		_, err = stmt.ExecContext(ctx, entry.insertArgs()...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // Get retrieves analytics entries for a specific short URL from the database.
-func (model *AnalyticsModel) GetByURLID(urlID int64) ([]*AnalyticsEntry, error) {
+func (model *AnalyticsModel) GetByURLID(ctx context.Context, urlID int64) ([]*AnalyticsEntry, error) {
 	query := `
-			SELECT id, url_id,ip, user_agent, referrer, timestamp
+			SELECT id, url_id, ip, user_agent, referrer, timestamp, browser, browser_version, os, device, country, region, city, bot
 			FROM analytics
 			WHERE url_id = ?;
 	`
-	rows, err := model.DB.Query(query, urlID)
+	rows, err := model.DB.QueryContext(ctx, query, urlID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var analytics []*AnalyticsEntry
+
+	err = scanEntries(rows, func(entry *AnalyticsEntry) error {
+		analytics = append(analytics, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return analytics, nil
+}
+
+// Stream calls fn for every entry of a short URL recorded in [from, to), in
+// the order they were recorded, reading rows from the cursor one at a time.
+func (model *AnalyticsModel) Stream(ctx context.Context, urlID int64, from, to time.Time, fn func(entry *AnalyticsEntry) error) error {
+	query := `
+			SELECT id, url_id, ip, user_agent, referrer, timestamp, browser, browser_version, os, device, country, region, city, bot
+			FROM analytics
+			WHERE url_id = ? AND ` + sqliteEpoch + ` >= ? AND ` + sqliteEpoch + ` < ?
+			ORDER BY id;
+	`
+	rows, err := model.DB.QueryContext(ctx, query, urlID, from.Unix(), to.Unix())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	return scanEntries(rows, fn)
+}
+
+// scanEntries calls fn for each full analytics row in rows.
+func scanEntries(rows *sql.Rows, fn func(entry *AnalyticsEntry) error) error {
+	for rows.Next() {
+		var entry AnalyticsEntry
+		err := rows.Scan(&entry.ID, &entry.URLID, &entry.IP, &entry.UserAgent, &entry.Referrer, &entry.Timestamp,
+			&entry.Browser, &entry.BrowserVersion, &entry.OS, &entry.Device, &entry.Country, &entry.Region, &entry.City, &entry.Bot)
+		if err != nil {
+			return err
+		}
+		err = fn(&entry)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetWithoutAgentDetails returns up to limit entries, ordered by id and starting
+// after afterID, whose user agent has not been parsed yet.
+func (model *AnalyticsModel) GetWithoutAgentDetails(ctx context.Context, afterID int64, limit int) ([]*AnalyticsEntry, error) {
+	query := `
+			SELECT id, user_agent
+			FROM analytics
+			WHERE device = '' AND id > ?
+			ORDER BY id
+			LIMIT ?;
+	`
+	rows, err := model.DB.QueryContext(ctx, query, afterID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -47,20 +182,291 @@ func (model *AnalyticsModel) GetByURLID(urlID int64) ([]*AnalyticsEntry, error)
 
 	for rows.Next() {
 		var entry AnalyticsEntry
-		err := rows.Scan(&entry.ID, &entry.URLID, &entry.IP, &entry.UserAgent, &entry.Referrer, &entry.Timestamp)
+		err := rows.Scan(&entry.ID, &entry.UserAgent)
 		if err != nil {
 			return nil, err
 		}
 		analytics = append(analytics, &entry)
 	}
 
-	return analytics, nil
+	return analytics, rows.Err()
+}
+
+// SetAgentDetails stores the parsed user agent fields of the given entries in a
+// single transaction. Entries already flagged as bots stay flagged.
+func (model *AnalyticsModel) SetAgentDetails(ctx context.Context, entries []*AnalyticsEntry) error {
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE analytics SET browser = ?, browser_version = ?, os = ?, device = ?, bot = (bot OR ?) WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		_, err = stmt.ExecContext(ctx, entry.Browser, entry.BrowserVersion, entry.OS, entry.Device, entry.Bot, entry.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteOlderThan deletes entries recorded before cutoff, except those on URLs
+// owned by the users in except, who have their own retention window.
+func (model *AnalyticsModel) DeleteOlderThan(ctx context.Context, cutoff time.Time, except []int64) (int64, error) {
+	query := `DELETE FROM analytics WHERE ` + sqliteEpoch + ` < ?`
+	args := []interface{}{cutoff.Unix()}
+	if len(except) > 0 {
+		query += ` AND url_id NOT IN (SELECT id FROM urls WHERE user_id IN (?` + strings.Repeat(", ?", len(except)-1) + `))`
+		for _, userID := range except {
+			args = append(args, userID)
+		}
+	}
+
+	res, err := model.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteOlderThanForUser deletes entries recorded before cutoff on URLs owned by userID.
+func (model *AnalyticsModel) DeleteOlderThanForUser(ctx context.Context, userID int64, cutoff time.Time) (int64, error) {
+	query := `DELETE FROM analytics WHERE ` + sqliteEpoch + ` < ? AND url_id IN (SELECT id FROM urls WHERE user_id = ?)`
+	res, err := model.DB.ExecContext(ctx, query, cutoff.Unix(), userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
-func (model *AnalyticsModel) DeleteByURLID(urlID int64) error {
-	_, err := model.DB.Exec("DELETE FROM analytics WHERE url_id = ?", urlID)
+func (model *AnalyticsModel) DeleteByURLID(ctx context.Context, urlID int64) error {
+	_, err := model.DB.ExecContext(ctx, "DELETE FROM analytics WHERE url_id = ?", urlID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// Supported bucket sizes for AnalyticsSummary.Series.
+const (
+	IntervalHour = "hour"
+	IntervalDay  = "day"
+	IntervalWeek = "week"
+)
+
+// maxSeriesBuckets caps how many buckets a single summary may span.
+const maxSeriesBuckets = 1000
+
+// AnalyticsFilter selects the clicks that go into an AnalyticsSummary.
+type AnalyticsFilter struct {
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+	Interval string    // one of IntervalHour, IntervalDay or IntervalWeek
+	Top      int       // number of entries in the top referrer and user agent lists
+
+	IncludeBots bool // count clicks flagged as bot traffic
+}
+
+// AnalyticsSummary is the aggregated view of the clicks on a short URL.
+type AnalyticsSummary struct {
+	From           time.Time      `json:"from"`
+	To             time.Time      `json:"to"`
+	Interval       string         `json:"interval"`
+	IncludeBots    bool           `json:"include_bots"`
+	TotalClicks    int64          `json:"total_clicks"`
+	BotClicks      int64          `json:"bot_clicks"`
+	UniqueVisitors int64          `json:"unique_visitors"`
+	Series         []SeriesBucket `json:"series"`
+	TopReferrers   []CountEntry   `json:"top_referrers"`
+	TopUserAgents  []CountEntry   `json:"top_user_agents"`
+	Browsers       []CountEntry   `json:"browsers"`
+	OS             []CountEntry   `json:"os"`
+	Devices        []CountEntry   `json:"devices"`
+	Countries      []CountEntry   `json:"countries"`
+}
+
+// breakdown pairs a counted column with the summary field it fills.
+type breakdown struct {
+	column string
+	dst    *[]CountEntry
+}
+
+func (summary *AnalyticsSummary) breakdowns() []breakdown {
+	return []breakdown{
+		{"COALESCE(referrer, '')", &summary.TopReferrers},
+		{"user_agent", &summary.TopUserAgents},
+		{"browser", &summary.Browsers},
+		{"os", &summary.OS},
+		{"device", &summary.Devices},
+		{"country", &summary.Countries},
+	}
+}
+
+// SeriesBucket holds the clicks that fell into one time bucket.
+type SeriesBucket struct {
+	Start          time.Time `json:"start"`
+	Clicks         int64     `json:"clicks"`
+	UniqueVisitors int64     `json:"unique_visitors"`
+}
+
+// CountEntry is a value together with the number of clicks that carried it.
+type CountEntry struct {
+	Value  string `json:"value"`
+	Clicks int64  `json:"clicks"`
+}
+
+func ValidateAnalyticsFilter(v *validator.Validator, filter AnalyticsFilter) {
+	v.Check(filter.Interval == IntervalHour || filter.Interval == IntervalDay || filter.Interval == IntervalWeek, "interval", "must be one of 'hour', 'day' or 'week'")
+	v.Check(filter.To.After(filter.From), "to", "must be after from")
+	v.Check(filter.Top > 0, "top", "must be greater than zero")
+	v.Check(filter.Top <= 100, "top", "must not be more than 100")
+
+	if v.Valid() {
+		buckets := (bucketStart(filter.To, filter.Interval) - bucketStart(filter.From, filter.Interval)) / intervalSeconds(filter.Interval)
+		v.Check(buckets < maxSeriesBuckets, "interval", "too many buckets for the requested range, use a larger interval")
+	}
+}
+
+// intervalSeconds returns the length of a bucket in seconds.
+func intervalSeconds(interval string) int64 {
+	switch interval {
+	case IntervalHour:
+		return 60 * 60
+	case IntervalWeek:
+		return 7 * 24 * 60 * 60
+	default:
+		return 24 * 60 * 60
+	}
+}
+
+// weekOffset shifts week buckets so they start on Monday; the Unix epoch fell on a Thursday.
+const weekOffset = 4 * 24 * 60 * 60
+
+// bucketStart returns the Unix time of the UTC bucket that t falls into.
+func bucketStart(t time.Time, interval string) int64 {
+	seconds := intervalSeconds(interval)
+	if interval == IntervalWeek {
+		return (t.Unix()-weekOffset)/seconds*seconds + weekOffset
+	}
+	return t.Unix() / seconds * seconds
+}
+
+// fillSeries turns the non-empty buckets reported by a store into a
+// continuous series covering the whole filter range.
+func fillSeries(filter AnalyticsFilter, buckets map[int64]SeriesBucket) []SeriesBucket {
+	step := intervalSeconds(filter.Interval)
+	end := filter.To.Unix()
+
+	series := []SeriesBucket{}
+	for start := bucketStart(filter.From, filter.Interval); start < end; start += step {
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = SeriesBucket{}
+		}
+		bucket.Start = time.Unix(start, 0).UTC()
+		series = append(series, bucket)
+	}
+	return series
+}
+
+// sqliteEpoch converts the stored timestamp to Unix seconds, whatever time zone it was written in.
+const sqliteEpoch = "CAST(strftime('%s', timestamp) AS INTEGER)"
+
+// Summarize aggregates the clicks on a short URL that match filter.
+func (model *AnalyticsModel) Summarize(ctx context.Context, urlID int64, filter AnalyticsFilter) (*AnalyticsSummary, error) {
+	summary := &AnalyticsSummary{
+		From:        filter.From,
+		To:          filter.To,
+		Interval:    filter.Interval,
+		IncludeBots: filter.IncludeBots,
+	}
+	args := []interface{}{urlID, filter.From.Unix(), filter.To.Unix()}
+	where := `WHERE url_id = ? AND ` + sqliteEpoch + ` >= ? AND ` + sqliteEpoch + ` < ?`
+
+	err := model.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM analytics `+where+` AND bot`, args...).Scan(&summary.BotClicks)
+	if err != nil {
+		return nil, err
+	}
+	if !filter.IncludeBots {
+		where += ` AND NOT bot`
+	}
+
+	err = model.DB.QueryRowContext(ctx, `SELECT COUNT(*), COUNT(DISTINCT ip) FROM analytics `+where, args...).Scan(&summary.TotalClicks, &summary.UniqueVisitors)
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := intervalSeconds(filter.Interval)
+	bucket := fmt.Sprintf("(%s / %d) * %d", sqliteEpoch, seconds, seconds)
+	if filter.Interval == IntervalWeek {
+		bucket = fmt.Sprintf("((%s - %d) / %d) * %d + %d", sqliteEpoch, weekOffset, seconds, seconds, weekOffset)
+	}
+	query := `SELECT ` + bucket + ` AS bucket, COUNT(*), COUNT(DISTINCT ip) FROM analytics ` + where + ` GROUP BY bucket`
+	buckets, err := scanSeries(ctx, model.DB, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	summary.Series = fillSeries(filter, buckets)
+
+	top := append(args, filter.Top)
+	for _, breakdown := range summary.breakdowns() {
+		*breakdown.dst, err = scanCounts(ctx, model.DB, `
+			SELECT `+breakdown.column+`, COUNT(*) AS clicks FROM analytics `+where+`
+			GROUP BY 1 ORDER BY clicks DESC, 1 LIMIT ?`, top...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
+
+// scanSeries runs a query returning (bucket start in Unix seconds, clicks, unique visitors) rows.
+func scanSeries(ctx context.Context, db *sql.DB, query string, args ...interface{}) (map[int64]SeriesBucket, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make(map[int64]SeriesBucket)
+	for rows.Next() {
+		var start int64
+		var bucket SeriesBucket
+		err := rows.Scan(&start, &bucket.Clicks, &bucket.UniqueVisitors)
+		if err != nil {
+			return nil, err
+		}
+		buckets[start] = bucket
+	}
+
+	return buckets, rows.Err()
+}
+
+// scanCounts runs a query returning (value, clicks) rows.
+func scanCounts(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]CountEntry, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []CountEntry{}
+	for rows.Next() {
+		var entry CountEntry
+		err := rows.Scan(&entry.Value, &entry.Clicks)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, entry)
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedURLModel wraps a URLStore with a bounded LRU cache in front of
+// GetByShort. Entries expire after the configured TTL and are dropped whenever
+// the underlying URL is updated or deleted through the wrapper.
+type CachedURLModel struct {
+	URLStore
+
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element // keyed by cacheKey
+	byID    map[int64]string
+	// generation counts invalidations. A lookup that missed only caches its
+	// result if no write invalidated anything while it was reading, as it may
+	// have read the row from before the write.
+	generation uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheEntry struct {
+	url     URL
+	expires time.Time
+}
+
+// NewCachedURLModel returns store wrapped by an LRU cache holding at most size entries for ttl each.
+func NewCachedURLModel(store URLStore, size int, ttl time.Duration) *CachedURLModel {
+	return &CachedURLModel{
+		URLStore: store,
+		size:     size,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		byID:     make(map[int64]string),
+	}
+}
+
+// cacheKey identifies a short code on a domain.
+func cacheKey(domain string, shortCode string) string {
+	return domain + "/" + shortCode
+}
+
+// GetByShort serves the URL from the cache when possible and falls back to the wrapped store.
+func (model *CachedURLModel) GetByShort(ctx context.Context, domain string, shortCode string) (*URL, error) {
+	model.mu.Lock()
+	if elem, ok := model.entries[cacheKey(domain, shortCode)]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			model.order.MoveToFront(elem)
+			url := entry.url
+			model.mu.Unlock()
+			model.hits.Add(1)
+			return &url, nil
+		}
+		model.remove(elem)
+	}
+	generation := model.generation
+	model.mu.Unlock()
+	model.misses.Add(1)
+
+	url, err := model.URLStore.GetByShort(ctx, domain, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	model.mu.Lock()
+	if model.generation == generation {
+		model.add(url)
+	}
+	model.mu.Unlock()
+
+	return url, nil
+}
+
+// Update modifies the URL in the wrapped store and invalidates its cached entry
+// both before and after the write, so that lookups racing it cannot cache the old row.
+func (model *CachedURLModel) Update(ctx context.Context, url *URL) error {
+	model.invalidate(url.ID, url.Domain, url.ShortCode)
+	err := model.URLStore.Update(ctx, url)
+	model.invalidate(url.ID, url.Domain, url.ShortCode)
+	return err
+}
+
+// DeleteExpired deletes expired URLs from the wrapped store and invalidates their cached entries.
+func (model *CachedURLModel) DeleteExpired(ctx context.Context, cutoff time.Time) ([]*URL, error) {
+	urls, err := model.URLStore.DeleteExpired(ctx, cutoff)
+	for _, url := range urls {
+		model.invalidate(url.ID, url.Domain, url.ShortCode)
+	}
+	return urls, err
+}
+
+// DeleteByDomain deletes the URLs on domain from the wrapped store and invalidates their cached entries.
+func (model *CachedURLModel) DeleteByDomain(ctx context.Context, domain string) ([]*URL, error) {
+	urls, err := model.URLStore.DeleteByDomain(ctx, domain)
+	for _, url := range urls {
+		model.invalidate(url.ID, url.Domain, url.ShortCode)
+	}
+	return urls, err
+}
+
+// DeleteByShort deletes the URL from the wrapped store and invalidates its
+// cached entry, before and after the write like Update.
+func (model *CachedURLModel) DeleteByShort(ctx context.Context, domain string, shortCode string) error {
+	model.invalidate(0, domain, shortCode)
+	err := model.URLStore.DeleteByShort(ctx, domain, shortCode)
+	model.invalidate(0, domain, shortCode)
+	return err
+}
+
+// Hits returns the number of GetByShort calls answered from the cache.
+func (model *CachedURLModel) Hits() int64 {
+	return model.hits.Load()
+}
+
+// Misses returns the number of GetByShort calls that reached the wrapped store.
+func (model *CachedURLModel) Misses() int64 {
+	return model.misses.Load()
+}
+
+// invalidate drops the cached entries for the URL with the given id, domain and short code.
+// The short code may have changed since the entry was cached, so both keys are checked.
+func (model *CachedURLModel) invalidate(id int64, domain string, shortCode string) {
+	model.mu.Lock()
+	defer model.mu.Unlock()
+
+	model.generation++
+
+	if elem, ok := model.entries[cacheKey(domain, shortCode)]; ok {
+		model.remove(elem)
+	}
+	if key, ok := model.byID[id]; ok {
+		if elem, ok := model.entries[key]; ok {
+			model.remove(elem)
+		}
+	}
+}
+
+// add caches a copy of url, evicting the least recently used entry when full.
+// The caller must hold mu.
+func (model *CachedURLModel) add(url *URL) {
+	if model.size <= 0 {
+		return
+	}
+	key := cacheKey(url.Domain, url.ShortCode)
+	if elem, ok := model.entries[key]; ok {
+		model.remove(elem)
+	}
+	for model.order.Len() >= model.size {
+		model.remove(model.order.Back())
+	}
+
+	elem := model.order.PushFront(&cacheEntry{url: *url, expires: time.Now().Add(model.ttl)})
+	model.entries[key] = elem
+	model.byID[url.ID] = key
+}
+
+// remove evicts a single entry. The caller must hold mu.
+func (model *CachedURLModel) remove(elem *list.Element) {
+	entry := model.order.Remove(elem).(*cacheEntry)
+	key := cacheKey(entry.url.Domain, entry.url.ShortCode)
+	delete(model.entries, key)
+	if model.byID[entry.url.ID] == key {
+		delete(model.byID, entry.url.ID)
+	}
+}
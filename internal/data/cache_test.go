@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newCachedTestModel returns a cache of size entries in front of a fresh
+// memory store holding a URL for each of the given short codes.
+func newCachedTestModel(t *testing.T, size int, ttl time.Duration, shortCodes ...string) (*CachedURLModel, URLStore) {
+	t.Helper()
+
+	store := NewMemoryModel().URLS
+	for _, shortCode := range shortCodes {
+		err := store.Insert(context.Background(), NewURL("", "https://example.com/"+shortCode, shortCode, 308, AnonymousUser, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewCachedURLModel(store, size, ttl), store
+}
+
+// mustGet looks shortCode up through the cache and fails the test if it is missing.
+func mustGet(t *testing.T, cache *CachedURLModel, shortCode string) *URL {
+	t.Helper()
+
+	url, err := cache.GetByShort(context.Background(), "", shortCode)
+	if err != nil {
+		t.Fatalf("looking up %q: %v", shortCode, err)
+	}
+	return url
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache, _ := newCachedTestModel(t, 2, time.Hour, "a", "b", "c")
+
+	mustGet(t, cache, "a")
+	mustGet(t, cache, "b")
+	mustGet(t, cache, "a") // a is now the most recently used
+	mustGet(t, cache, "c") // evicts b
+
+	if hits, misses := cache.Hits(), cache.Misses(); hits != 1 || misses != 3 {
+		t.Fatalf("got %d hits and %d misses, want 1 and 3", hits, misses)
+	}
+
+	mustGet(t, cache, "a")
+	mustGet(t, cache, "c")
+	if hits := cache.Hits(); hits != 3 {
+		t.Errorf("got %d hits for the two most recently used entries, want 3", hits)
+	}
+	mustGet(t, cache, "b")
+	if misses := cache.Misses(); misses != 4 {
+		t.Errorf("got %d misses for the evicted entry, want 4", misses)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	cache, _ := newCachedTestModel(t, 10, 20*time.Millisecond, "a")
+
+	mustGet(t, cache, "a")
+	mustGet(t, cache, "a")
+	if hits := cache.Hits(); hits != 1 {
+		t.Fatalf("got %d hits before the entry expired, want 1", hits)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	mustGet(t, cache, "a")
+	if hits, misses := cache.Hits(), cache.Misses(); hits != 1 || misses != 2 {
+		t.Errorf("got %d hits and %d misses after the entry expired, want 1 and 2", hits, misses)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		write func(t *testing.T, cache *CachedURLModel, url *URL)
+		// want is the long URL then found for the cached short code, or
+		// empty when it should be gone.
+		want string
+	}{
+		{
+			name: "Update",
+			write: func(t *testing.T, cache *CachedURLModel, url *URL) {
+				url.LongForm = "https://example.com/changed"
+				if err := cache.Update(ctx, url); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "https://example.com/changed",
+		},
+		{
+			name: "Update renaming the short code",
+			write: func(t *testing.T, cache *CachedURLModel, url *URL) {
+				url.ShortCode = "renamed"
+				if err := cache.Update(ctx, url); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "DeleteByShort",
+			write: func(t *testing.T, cache *CachedURLModel, url *URL) {
+				if err := cache.DeleteByShort(ctx, "", url.ShortCode); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "DeleteExpired",
+			write: func(t *testing.T, cache *CachedURLModel, url *URL) {
+				if _, err := cache.DeleteExpired(ctx, url.Expired.Add(time.Second)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "DeleteByDomain",
+			write: func(t *testing.T, cache *CachedURLModel, url *URL) {
+				if _, err := cache.DeleteByDomain(ctx, url.Domain); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, _ := newCachedTestModel(t, 10, time.Hour, "a")
+
+			tt.write(t, cache, mustGet(t, cache, "a"))
+
+			url, err := cache.GetByShort(ctx, "", "a")
+			switch {
+			case tt.want == "" && !errors.Is(err, ErrRecordNotFound):
+				t.Errorf("got %v, %v after the write, want ErrRecordNotFound", url, err)
+			case tt.want != "" && err != nil:
+				t.Errorf("looking up after the write: %v", err)
+			case tt.want != "" && url.LongForm != tt.want:
+				t.Errorf("got %q after the write, want %q", url.LongForm, tt.want)
+			}
+		})
+	}
+}
+
+// slowURLStore holds GetByShort between reading the row and returning it,
+// until release is closed, signalling read once the row is read.
+type slowURLStore struct {
+	URLStore
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (store *slowURLStore) GetByShort(ctx context.Context, domain string, shortCode string) (*URL, error) {
+	url, err := store.URLStore.GetByShort(ctx, domain, shortCode)
+	close(store.read)
+	<-store.release
+	return url, err
+}
+
+func TestCacheMissRacingUpdate(t *testing.T) {
+	ctx := context.Background()
+	_, store := newCachedTestModel(t, 0, 0, "a")
+	slow := &slowURLStore{URLStore: store, read: make(chan struct{}), release: make(chan struct{})}
+	cache := NewCachedURLModel(slow, 10, time.Hour)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.GetByShort(ctx, "", "a")
+	}()
+
+	// The lookup has read the old row, and the update lands before it returns.
+	<-slow.read
+	url, err := store.GetByShort(ctx, "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url.LongForm = "https://example.com/changed"
+	err = cache.Update(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(slow.release)
+	<-done
+
+	slow.read = make(chan struct{})
+	got := mustGet(t, cache, "a")
+	if got.LongForm != "https://example.com/changed" {
+		t.Errorf("got %q after the update, want the changed URL", got.LongForm)
+	}
+}
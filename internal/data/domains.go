@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"regexp"
+	"strings"
+	"time"
+	"url_shortner/internal/validator"
+)
+
+// Domain is a short domain claimed by a user. Short codes are unique per
+// domain, so the same code can point somewhere else on each of them. Several
+// users may claim a host, but only the one who proves control of it through
+// the verification token gets to use it.
+type Domain struct {
+	ID       int64     `json:"-"`
+	UserID   int64     `json:"-"`
+	Host     string    `json:"host"`
+	Token    string    `json:"verification_token"`
+	Verified bool      `json:"verified"`
+	Created  time.Time `json:"created"`
+}
+
+// NewDomain returns an unverified claim on host with a fresh verification token.
+func NewDomain(userID int64, host string) (*Domain, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Domain{
+		UserID:  userID,
+		Host:    host,
+		Token:   base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+		Created: time.Now(),
+	}, nil
+}
+
+// hostRX matches a lower-case DNS name with at least two labels.
+var hostRX = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// NormalizeHost lower-cases host and strips any port and trailing dot, giving
+// the form domains are stored and looked up in.
+func NormalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// ValidateHost checks that host is a normalized domain name.
+func ValidateHost(v *validator.Validator, key string, host string) {
+	v.Check(host != "", key, "must be provided")
+	v.Check(len(host) <= 253, key, "must not be more than 253 bytes long")
+	v.Check(v.Matches(host, hostRX), key, "must be a valid domain name")
+}
+
+// DomainModel is the SQLite implementation of DomainStore.
+type DomainModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a claim on a domain, rejecting hosts the user already claimed.
+func (m DomainModel) Insert(ctx context.Context, domain *Domain) error {
+	query := `
+		INSERT INTO domains (user_id, host, verification_token, verified, created)
+		VALUES (?, ?, ?, ?, ?)`
+
+	res, err := m.DB.ExecContext(ctx, query, domain.UserID, domain.Host, domain.Token, domain.Verified, domain.Created)
+	if err != nil {
+		return sqliteError(err, ErrDuplicateEntry)
+	}
+
+	domain.ID, err = res.LastInsertId()
+	return err
+}
+
+// Get returns a user's claim on host.
+func (m DomainModel) Get(ctx context.Context, userID int64, host string) (*Domain, error) {
+	query := `
+		SELECT id, user_id, host, verification_token, verified, created
+		FROM domains
+		WHERE user_id = ? AND host = ?`
+
+	return m.scanOne(m.DB.QueryRowContext(ctx, query, userID, host))
+}
+
+// GetVerified returns the verified claim on host.
+func (m DomainModel) GetVerified(ctx context.Context, host string) (*Domain, error) {
+	query := `
+		SELECT id, user_id, host, verification_token, verified, created
+		FROM domains
+		WHERE host = ? AND verified = 1`
+
+	return m.scanOne(m.DB.QueryRowContext(ctx, query, host))
+}
+
+func (m DomainModel) scanOne(row *sql.Row) (*Domain, error) {
+	var domain Domain
+	err := row.Scan(&domain.ID, &domain.UserID, &domain.Host, &domain.Token, &domain.Verified, &domain.Created)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// GetAllForUser returns the domains claimed by a user.
+func (m DomainModel) GetAllForUser(ctx context.Context, userID int64) ([]*Domain, error) {
+	query := `
+		SELECT id, user_id, host, verification_token, verified, created
+		FROM domains
+		WHERE user_id = ?
+		ORDER BY host`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []*Domain{}
+	for rows.Next() {
+		var domain Domain
+		err := rows.Scan(&domain.ID, &domain.UserID, &domain.Host, &domain.Token, &domain.Verified, &domain.Created)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, &domain)
+	}
+	return domains, rows.Err()
+}
+
+// MarkVerified marks a claim as verified and drops the other users' unverified
+// claims on the host. It returns ErrDuplicateEntry when another user already
+// verified the host.
+func (m DomainModel) MarkVerified(ctx context.Context, domain *Domain) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE domains SET verified = 1 WHERE id = ?`, domain.ID)
+	if err != nil {
+		return sqliteError(err, ErrDuplicateEntry)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM domains WHERE host = ? AND id <> ? AND verified = 0`, domain.Host, domain.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	domain.Verified = true
+	return nil
+}
+
+// Delete removes a user's claim on a domain, returning ErrRecordNotFound when they have none.
+func (m DomainModel) Delete(ctx context.Context, userID int64, host string) error {
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM domains WHERE user_id = ? AND host = ?`, userID, host)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
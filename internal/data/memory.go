@@ -0,0 +1,795 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryDB holds the state shared by the in-memory stores. It is meant for
+// tests and local experiments; nothing survives a restart.
+type memoryDB struct {
+	mu sync.RWMutex
+
+	urls      map[int64]*URL
+	analytics map[int64]*AnalyticsEntry
+	users     map[int64]*User
+	tokens    []*Token
+	privacy   map[int64]*PrivacySettings
+	domains   map[int64]*Domain
+
+	nextURLID       int64
+	nextAnalyticsID int64
+	nextUserID      int64
+	nextDomainID    int64
+}
+
+// NewMemoryModel returns a Model whose stores keep everything in process memory.
+func NewMemoryModel() Model {
+	db := &memoryDB{
+		urls:      make(map[int64]*URL),
+		analytics: make(map[int64]*AnalyticsEntry),
+		users:     make(map[int64]*User),
+		privacy:   make(map[int64]*PrivacySettings),
+		domains:   make(map[int64]*Domain),
+	}
+	return Model{
+		URLS:      &MemoryURLModel{db: db},
+		Analytics: &MemoryAnalyticsModel{db: db},
+		Tokens:    MemoryTokenModel{db: db},
+		Users:     MemoryUserModel{db: db},
+		Privacy:   MemoryPrivacyModel{db: db},
+		Domains:   MemoryDomainModel{db: db},
+	}
+}
+
+// MemoryURLModel is the in-memory implementation of URLStore.
+type MemoryURLModel struct {
+	db *memoryDB
+}
+
+// Insert inserts a new URL record, rejecting duplicate short codes on the same domain.
+func (model *MemoryURLModel) Insert(ctx context.Context, url *URL) error {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	for _, existing := range model.db.urls {
+		if existing.Domain == url.Domain && existing.ShortCode == url.ShortCode {
+			return ErrDuplicateEntry
+		}
+	}
+
+	model.db.nextURLID++
+	url.ID = model.db.nextURLID
+	stored := *url
+	model.db.urls[url.ID] = &stored
+
+	return nil
+}
+
+// GetByShort retrieves a URL record based on its domain and short code.
+func (model *MemoryURLModel) GetByShort(ctx context.Context, domain string, shortCode string) (*URL, error) {
+	model.db.mu.RLock()
+	defer model.db.mu.RUnlock()
+
+	for _, url := range model.db.urls {
+		if url.Domain == domain && url.ShortCode == shortCode {
+			found := *url
+			return &found, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// GetAllForUser retrieves all urls by the user.
+func (model *MemoryURLModel) GetAllForUser(ctx context.Context, userID int64) ([]*URL, error) {
+	model.db.mu.RLock()
+	defer model.db.mu.RUnlock()
+
+	var urls []*URL
+	for _, url := range model.db.urls {
+		if url.UserID == userID {
+			found := *url
+			urls = append(urls, &found)
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].ID < urls[j].ID })
+
+	return urls, nil
+}
+
+// DeleteByShort deletes the URL with the given domain and short code.
+func (model *MemoryURLModel) DeleteByShort(ctx context.Context, domain string, shortCode string) error {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	for id, url := range model.db.urls {
+		if url.Domain == domain && url.ShortCode == shortCode {
+			delete(model.db.urls, id)
+		}
+	}
+	return nil
+}
+
+// DeleteExpired deletes the URLs that expired before cutoff, together with their analytics, and returns them.
+func (model *MemoryURLModel) DeleteExpired(ctx context.Context, cutoff time.Time) ([]*URL, error) {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	var urls []*URL
+	for id, url := range model.db.urls {
+		if !url.Expired.IsZero() && url.Expired.Before(cutoff) {
+			urls = append(urls, url)
+			delete(model.db.urls, id)
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].ID < urls[j].ID })
+
+	for id, entry := range model.db.analytics {
+		if _, ok := model.db.urls[entry.URLID]; !ok {
+			delete(model.db.analytics, id)
+		}
+	}
+
+	return urls, nil
+}
+
+// DeleteByDomain deletes every URL on domain, together with their analytics, and returns them.
+func (model *MemoryURLModel) DeleteByDomain(ctx context.Context, domain string) ([]*URL, error) {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	var urls []*URL
+	for id, url := range model.db.urls {
+		if url.Domain == domain {
+			urls = append(urls, url)
+			delete(model.db.urls, id)
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].ID < urls[j].ID })
+
+	for id, entry := range model.db.analytics {
+		if _, ok := model.db.urls[entry.URLID]; !ok {
+			delete(model.db.analytics, id)
+		}
+	}
+
+	return urls, nil
+}
+
+// Update modifies an existing URL record.
+func (model *MemoryURLModel) Update(ctx context.Context, url *URL) error {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	stored, ok := model.db.urls[url.ID]
+	if !ok {
+		return nil
+	}
+	for id, existing := range model.db.urls {
+		if id != url.ID && existing.Domain == stored.Domain && existing.ShortCode == url.ShortCode {
+			return ErrDuplicateEntry
+		}
+	}
+
+	stored.LongForm = url.LongForm
+	stored.ShortCode = url.ShortCode
+	stored.Redirect = url.Redirect
+	stored.UserID = url.UserID
+	stored.Expired = url.Expired
+	stored.Once = url.Once
+
+	return nil
+}
+
+// GetByLongURL retrieves a URL record on domain based on the long URL.
+func (model *MemoryURLModel) GetByLongURL(ctx context.Context, domain string, longURL string, redirectType int, userID int64) (*URL, error) {
+	model.db.mu.RLock()
+	defer model.db.mu.RUnlock()
+
+	var match *URL
+	for _, url := range model.db.urls {
+		if url.Domain == domain && url.LongForm == longURL && url.Redirect == redirectType && url.UserID == userID {
+			if match == nil || url.ID < match.ID {
+				match = url
+			}
+		}
+	}
+	if match == nil {
+		return nil, ErrRecordNotFound
+	}
+	found := *match
+	return &found, nil
+}
+
+// MemoryAnalyticsModel is the in-memory implementation of AnalyticsStore.
+type MemoryAnalyticsModel struct {
+	db *memoryDB
+}
+
+// Insert adds a new analytics entry.
+func (model *MemoryAnalyticsModel) Insert(ctx context.Context, entry *AnalyticsEntry) error {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	model.db.nextAnalyticsID++
+	entry.ID = model.db.nextAnalyticsID
+	stored := *entry
+	model.db.analytics[entry.ID] = &stored
+
+	return nil
+}
+
+// InsertBatch adds several analytics entries at once.
+func (model *MemoryAnalyticsModel) InsertBatch(ctx context.Context, entries []*AnalyticsEntry) error {
+	for _, entry := range entries {
+		if err := model.Insert(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetByURLID retrieves analytics entries for a specific short URL.
+func (model *MemoryAnalyticsModel) GetByURLID(ctx context.Context, urlID int64) ([]*AnalyticsEntry, error) {
+	model.db.mu.RLock()
+	defer model.db.mu.RUnlock()
+
+	var analytics []*AnalyticsEntry
+	for _, entry := range model.db.analytics {
+		if entry.URLID == urlID {
+			found := *entry
+			analytics = append(analytics, &found)
+		}
+	}
+	sort.Slice(analytics, func(i, j int) bool { return analytics[i].ID < analytics[j].ID })
+
+	return analytics, nil
+}
+
+// Stream calls fn for every entry of a short URL recorded in [from, to), in
+// the order they were recorded.
+func (model *MemoryAnalyticsModel) Stream(ctx context.Context, urlID int64, from, to time.Time, fn func(entry *AnalyticsEntry) error) error {
+	entries, err := model.GetByURLID(ctx, urlID)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Timestamp.Before(from) || !entry.Timestamp.Before(to) {
+			continue
+		}
+		err = fn(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetWithoutAgentDetails returns up to limit entries, ordered by id and starting
+// after afterID, whose user agent has not been parsed yet.
+func (model *MemoryAnalyticsModel) GetWithoutAgentDetails(ctx context.Context, afterID int64, limit int) ([]*AnalyticsEntry, error) {
+	model.db.mu.RLock()
+	defer model.db.mu.RUnlock()
+
+	var analytics []*AnalyticsEntry
+	for _, entry := range model.db.analytics {
+		if entry.Device == "" && entry.ID > afterID {
+			found := *entry
+			analytics = append(analytics, &found)
+		}
+	}
+	sort.Slice(analytics, func(i, j int) bool { return analytics[i].ID < analytics[j].ID })
+	if len(analytics) > limit {
+		analytics = analytics[:limit]
+	}
+
+	return analytics, nil
+}
+
+// SetAgentDetails stores the parsed user agent fields of the given entries.
+func (model *MemoryAnalyticsModel) SetAgentDetails(ctx context.Context, entries []*AnalyticsEntry) error {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	for _, entry := range entries {
+		if stored, ok := model.db.analytics[entry.ID]; ok {
+			stored.Browser = entry.Browser
+			stored.BrowserVersion = entry.BrowserVersion
+			stored.OS = entry.OS
+			stored.Device = entry.Device
+			stored.Bot = stored.Bot || entry.Bot
+		}
+	}
+	return nil
+}
+
+// Summarize aggregates the clicks on a short URL that match filter.
+func (model *MemoryAnalyticsModel) Summarize(ctx context.Context, urlID int64, filter AnalyticsFilter) (*AnalyticsSummary, error) {
+	model.db.mu.RLock()
+	defer model.db.mu.RUnlock()
+
+	summary := &AnalyticsSummary{
+		From:        filter.From,
+		To:          filter.To,
+		Interval:    filter.Interval,
+		IncludeBots: filter.IncludeBots,
+	}
+
+	visitors := make(map[string]bool)
+	bucketVisitors := make(map[int64]map[string]bool)
+	buckets := make(map[int64]SeriesBucket)
+	referrers := make(map[string]int64)
+	userAgents := make(map[string]int64)
+	browsers := make(map[string]int64)
+	systems := make(map[string]int64)
+	devices := make(map[string]int64)
+	countries := make(map[string]int64)
+
+	for _, entry := range model.db.analytics {
+		if entry.URLID != urlID || entry.Timestamp.Before(filter.From) || !entry.Timestamp.Before(filter.To) {
+			continue
+		}
+		if entry.Bot {
+			summary.BotClicks++
+			if !filter.IncludeBots {
+				continue
+			}
+		}
+
+		summary.TotalClicks++
+		visitors[entry.IP] = true
+
+		start := bucketStart(entry.Timestamp, filter.Interval)
+		if bucketVisitors[start] == nil {
+			bucketVisitors[start] = make(map[string]bool)
+		}
+		bucketVisitors[start][entry.IP] = true
+		bucket := buckets[start]
+		bucket.Clicks++
+		bucket.UniqueVisitors = int64(len(bucketVisitors[start]))
+		buckets[start] = bucket
+
+		referrers[entry.Referrer]++
+		userAgents[entry.UserAgent]++
+		browsers[entry.Browser]++
+		systems[entry.OS]++
+		devices[entry.Device]++
+		countries[entry.Country]++
+	}
+
+	summary.UniqueVisitors = int64(len(visitors))
+	summary.Series = fillSeries(filter, buckets)
+	summary.TopReferrers = topCounts(referrers, filter.Top)
+	summary.TopUserAgents = topCounts(userAgents, filter.Top)
+	summary.Browsers = topCounts(browsers, filter.Top)
+	summary.OS = topCounts(systems, filter.Top)
+	summary.Devices = topCounts(devices, filter.Top)
+	summary.Countries = topCounts(countries, filter.Top)
+
+	return summary, nil
+}
+
+// topCounts returns the n values with the most clicks, ordered like the SQL stores order them.
+func topCounts(counts map[string]int64, n int) []CountEntry {
+	entries := []CountEntry{}
+	for value, clicks := range counts {
+		entries = append(entries, CountEntry{Value: value, Clicks: clicks})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Clicks != entries[j].Clicks {
+			return entries[i].Clicks > entries[j].Clicks
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// DeleteOlderThan deletes entries recorded before cutoff, except those on URLs
+// owned by the users in except, who have their own retention window.
+func (model *MemoryAnalyticsModel) DeleteOlderThan(ctx context.Context, cutoff time.Time, except []int64) (int64, error) {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	return model.db.deleteAnalytics(func(entry *AnalyticsEntry, owner int64) bool {
+		for _, userID := range except {
+			if owner == userID {
+				return false
+			}
+		}
+		return entry.Timestamp.Before(cutoff)
+	}), nil
+}
+
+// DeleteOlderThanForUser deletes entries recorded before cutoff on URLs owned by userID.
+func (model *MemoryAnalyticsModel) DeleteOlderThanForUser(ctx context.Context, userID int64, cutoff time.Time) (int64, error) {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	return model.db.deleteAnalytics(func(entry *AnalyticsEntry, owner int64) bool {
+		return owner == userID && entry.Timestamp.Before(cutoff)
+	}), nil
+}
+
+// deleteAnalytics removes every entry matching fn, which also receives the ID of
+// the user owning the entry's URL. The caller must hold the write lock.
+func (db *memoryDB) deleteAnalytics(fn func(entry *AnalyticsEntry, owner int64) bool) int64 {
+	var deleted int64
+	for id, entry := range db.analytics {
+		owner := int64(-1)
+		if url, ok := db.urls[entry.URLID]; ok {
+			owner = url.UserID
+		}
+		if fn(entry, owner) {
+			delete(db.analytics, id)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (model *MemoryAnalyticsModel) DeleteByURLID(ctx context.Context, urlID int64) error {
+	model.db.mu.Lock()
+	defer model.db.mu.Unlock()
+
+	for id, entry := range model.db.analytics {
+		if entry.URLID == urlID {
+			delete(model.db.analytics, id)
+		}
+	}
+	return nil
+}
+
+// MemoryUserModel is the in-memory implementation of UserStore.
+type MemoryUserModel struct {
+	db *memoryDB
+}
+
+func (m MemoryUserModel) Insert(ctx context.Context, user *User) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, existing := range m.db.users {
+		if existing.Email == user.Email {
+			return ErrDuplicateEmail
+		}
+	}
+
+	m.db.nextUserID++
+	user.ID = m.db.nextUserID
+	user.CreatedAt = time.Now()
+	stored := *user
+	m.db.users[user.ID] = &stored
+
+	return nil
+}
+
+func (m MemoryUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.db.mu.RLock()
+	defer m.db.mu.RUnlock()
+
+	for _, user := range m.db.users {
+		if user.Email == email {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m MemoryUserModel) SetUserType(ctx context.Context, userID int64, userType int) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if user, ok := m.db.users[userID]; ok {
+		user.Type = userType
+	}
+	return nil
+}
+
+func (m MemoryUserModel) SetEmail(ctx context.Context, userID int64, email string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for id, existing := range m.db.users {
+		if id != userID && existing.Email == email {
+			return ErrDuplicateEmail
+		}
+	}
+	if user, ok := m.db.users[userID]; ok {
+		user.Email = email
+	}
+	return nil
+}
+
+func (m MemoryUserModel) SetPassword(ctx context.Context, userID int64, passwordHash string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if user, ok := m.db.users[userID]; ok {
+		user.Password.Hash = []byte(passwordHash)
+	}
+	return nil
+}
+
+func (m MemoryUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.db.mu.RLock()
+	defer m.db.mu.RUnlock()
+
+	now := time.Now()
+	for _, token := range m.db.tokens {
+		if bytes.Equal(token.Hash, tokenHash[:]) && token.Scope == tokenScope && token.Expiry.After(now) {
+			user, ok := m.db.users[token.UserID]
+			if !ok {
+				break
+			}
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m MemoryUserModel) Delete(ctx context.Context, userID int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.users[userID]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.db.users, userID)
+	delete(m.db.privacy, userID)
+
+	for id, url := range m.db.urls {
+		if url.UserID != userID {
+			continue
+		}
+		for entryID, entry := range m.db.analytics {
+			if entry.URLID == id {
+				delete(m.db.analytics, entryID)
+			}
+		}
+		delete(m.db.urls, id)
+	}
+	for id, domain := range m.db.domains {
+		if domain.UserID == userID {
+			delete(m.db.domains, id)
+		}
+	}
+
+	tokens := m.db.tokens[:0]
+	for _, token := range m.db.tokens {
+		if token.UserID != userID {
+			tokens = append(tokens, token)
+		}
+	}
+	m.db.tokens = tokens
+
+	return nil
+}
+
+// MemoryTokenModel is the in-memory implementation of TokenStore.
+type MemoryTokenModel struct {
+	db *memoryDB
+}
+
+func (m MemoryTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m MemoryTokenModel) Insert(ctx context.Context, token *Token) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored := *token
+	m.db.tokens = append(m.db.tokens, &stored)
+	return nil
+}
+
+func (m MemoryTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	m.db.deleteTokens(func(token *Token) bool {
+		return token.Scope == scope && token.UserID == userID
+	})
+	return nil
+}
+
+// DeleteExpired deletes every token that expired before cutoff and returns how many were deleted.
+func (m MemoryTokenModel) DeleteExpired(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	return m.db.deleteTokens(func(token *Token) bool {
+		return token.Expiry.Before(cutoff)
+	}), nil
+}
+
+func (m MemoryTokenModel) DeleteOneForUser(ctx context.Context, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	m.db.deleteTokens(func(token *Token) bool {
+		return bytes.Equal(token.Hash, tokenHash[:])
+	})
+	return nil
+}
+
+// deleteTokens removes every token matching fn and returns how many were
+// removed. The caller must hold the write lock.
+func (db *memoryDB) deleteTokens(fn func(token *Token) bool) int64 {
+	kept := db.tokens[:0]
+	for _, token := range db.tokens {
+		if !fn(token) {
+			kept = append(kept, token)
+		}
+	}
+	deleted := int64(len(db.tokens) - len(kept))
+	db.tokens = kept
+	return deleted
+}
+
+// MemoryPrivacyModel is the in-memory implementation of PrivacyStore.
+type MemoryPrivacyModel struct {
+	db *memoryDB
+}
+
+// Get returns the settings of a user, or empty settings when they have none.
+func (m MemoryPrivacyModel) Get(ctx context.Context, userID int64) (*PrivacySettings, error) {
+	m.db.mu.RLock()
+	defer m.db.mu.RUnlock()
+
+	if settings, ok := m.db.privacy[userID]; ok {
+		found := *settings
+		return &found, nil
+	}
+	return &PrivacySettings{UserID: userID}, nil
+}
+
+// GetAll returns the settings of every user who has overridden a default.
+func (m MemoryPrivacyModel) GetAll(ctx context.Context) ([]*PrivacySettings, error) {
+	m.db.mu.RLock()
+	defer m.db.mu.RUnlock()
+
+	var all []*PrivacySettings
+	for _, settings := range m.db.privacy {
+		found := *settings
+		all = append(all, &found)
+	}
+	return all, nil
+}
+
+// Set stores the settings of a user, replacing any previous ones.
+func (m MemoryPrivacyModel) Set(ctx context.Context, settings *PrivacySettings) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored := *settings
+	m.db.privacy[settings.UserID] = &stored
+	return nil
+}
+
+// MemoryDomainModel is the in-memory implementation of DomainStore.
+type MemoryDomainModel struct {
+	db *memoryDB
+}
+
+// Insert adds a claim on a domain, rejecting hosts the user already claimed.
+func (m MemoryDomainModel) Insert(ctx context.Context, domain *Domain) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, existing := range m.db.domains {
+		if existing.Host == domain.Host && existing.UserID == domain.UserID {
+			return ErrDuplicateEntry
+		}
+	}
+
+	m.db.nextDomainID++
+	domain.ID = m.db.nextDomainID
+	stored := *domain
+	m.db.domains[domain.ID] = &stored
+	return nil
+}
+
+// Get returns a user's claim on host.
+func (m MemoryDomainModel) Get(ctx context.Context, userID int64, host string) (*Domain, error) {
+	return m.find(func(domain *Domain) bool {
+		return domain.UserID == userID && domain.Host == host
+	})
+}
+
+// GetVerified returns the verified claim on host.
+func (m MemoryDomainModel) GetVerified(ctx context.Context, host string) (*Domain, error) {
+	return m.find(func(domain *Domain) bool {
+		return domain.Verified && domain.Host == host
+	})
+}
+
+func (m MemoryDomainModel) find(match func(domain *Domain) bool) (*Domain, error) {
+	m.db.mu.RLock()
+	defer m.db.mu.RUnlock()
+
+	for _, domain := range m.db.domains {
+		if match(domain) {
+			found := *domain
+			return &found, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// GetAllForUser returns the domains claimed by a user.
+func (m MemoryDomainModel) GetAllForUser(ctx context.Context, userID int64) ([]*Domain, error) {
+	m.db.mu.RLock()
+	defer m.db.mu.RUnlock()
+
+	domains := []*Domain{}
+	for _, domain := range m.db.domains {
+		if domain.UserID == userID {
+			found := *domain
+			domains = append(domains, &found)
+		}
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Host < domains[j].Host })
+	return domains, nil
+}
+
+// MarkVerified marks a claim as verified and drops the other users' unverified
+// claims on the host. It returns ErrDuplicateEntry when another user already
+// verified the host.
+func (m MemoryDomainModel) MarkVerified(ctx context.Context, domain *Domain) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored, ok := m.db.domains[domain.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	for _, other := range m.db.domains {
+		if other.ID != domain.ID && other.Host == domain.Host && other.Verified {
+			return ErrDuplicateEntry
+		}
+	}
+
+	for id, other := range m.db.domains {
+		if other.ID != domain.ID && other.Host == domain.Host {
+			delete(m.db.domains, id)
+		}
+	}
+	stored.Verified = true
+	domain.Verified = true
+	return nil
+}
+
+// Delete removes a user's claim on a domain, returning ErrRecordNotFound when they have none.
+func (m MemoryDomainModel) Delete(ctx context.Context, userID int64, host string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for id, domain := range m.db.domains {
+		if domain.UserID == userID && domain.Host == host {
+			delete(m.db.domains, id)
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
@@ -1,19 +1,92 @@
 package data
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"time"
+)
 
+// URLStore is implemented by every backend capable of persisting short URLs.
+type URLStore interface {
+	Insert(ctx context.Context, url *URL) error
+	GetByShort(ctx context.Context, domain string, shortCode string) (*URL, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*URL, error)
+	DeleteByShort(ctx context.Context, domain string, shortCode string) error
+	Update(ctx context.Context, url *URL) error
+	GetByLongURL(ctx context.Context, domain string, longURL string, redirectType int, userID int64) (*URL, error)
+	DeleteExpired(ctx context.Context, cutoff time.Time) ([]*URL, error)
+	DeleteByDomain(ctx context.Context, domain string) ([]*URL, error)
+}
+
+// AnalyticsStore is implemented by every backend capable of persisting analytics entries.
+type AnalyticsStore interface {
+	Insert(ctx context.Context, entry *AnalyticsEntry) error
+	InsertBatch(ctx context.Context, entries []*AnalyticsEntry) error
+	GetByURLID(ctx context.Context, urlID int64) ([]*AnalyticsEntry, error)
+	Summarize(ctx context.Context, urlID int64, filter AnalyticsFilter) (*AnalyticsSummary, error)
+	Stream(ctx context.Context, urlID int64, from, to time.Time, fn func(entry *AnalyticsEntry) error) error
+	GetWithoutAgentDetails(ctx context.Context, afterID int64, limit int) ([]*AnalyticsEntry, error)
+	SetAgentDetails(ctx context.Context, entries []*AnalyticsEntry) error
+	DeleteOlderThan(ctx context.Context, cutoff time.Time, except []int64) (int64, error)
+	DeleteOlderThanForUser(ctx context.Context, userID int64, cutoff time.Time) (int64, error)
+	DeleteByURLID(ctx context.Context, urlID int64) error
+}
+
+// UserStore is implemented by every backend capable of persisting users.
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	SetUserType(ctx context.Context, userID int64, userType int) error
+	SetEmail(ctx context.Context, userID int64, email string) error
+	SetPassword(ctx context.Context, userID int64, passwordHash string) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	Delete(ctx context.Context, userID int64) error
+}
+
+// TokenStore is implemented by every backend capable of persisting tokens.
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteOneForUser(ctx context.Context, tokenPlaintext string) error
+	DeleteExpired(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// PrivacyStore is implemented by every backend capable of persisting per-user privacy settings.
+type PrivacyStore interface {
+	Get(ctx context.Context, userID int64) (*PrivacySettings, error)
+	GetAll(ctx context.Context) ([]*PrivacySettings, error)
+	Set(ctx context.Context, settings *PrivacySettings) error
+}
+
+// DomainStore is implemented by every backend capable of persisting user short domains.
+type DomainStore interface {
+	Insert(ctx context.Context, domain *Domain) error
+	Get(ctx context.Context, userID int64, host string) (*Domain, error)
+	GetVerified(ctx context.Context, host string) (*Domain, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*Domain, error)
+	MarkVerified(ctx context.Context, domain *Domain) error
+	Delete(ctx context.Context, userID int64, host string) error
+}
+
+// Model groups the stores used by the application, independent of the backend behind them.
 type Model struct {
-	URLS      URLModel
-	Analytics AnalyticsModel
-	Tokens    TokenModel
-	Users     UserModel
+	URLS      URLStore
+	Analytics AnalyticsStore
+	Tokens    TokenStore
+	Users     UserStore
+	Privacy   PrivacyStore
+	Domains   DomainStore
 }
 
+// NewModel returns a Model backed by the given SQLite database.
 func NewModel(db *sql.DB) Model {
 	return Model{
-		URLS:      URLModel{DB: db},
-		Analytics: AnalyticsModel{DB: db},
+		URLS:      &URLModel{DB: db},
+		Analytics: &AnalyticsModel{DB: db},
 		Tokens:    TokenModel{DB: db},
 		Users:     UserModel{DB: db},
+		Privacy:   PrivacyModel{DB: db},
+		Domains:   DomainModel{DB: db},
 	}
 }
@@ -0,0 +1,333 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Observer is called as each store call starts and returns the context to run
+// the call with, along with a function told how the call ended. It is how
+// callers outside the package trace and time queries without the backends
+// knowing about it.
+type Observer func(ctx context.Context, store string, operation string) (context.Context, func(err error))
+
+// ObserveModel wraps every store of m so that each call is reported to observe.
+func ObserveModel(m Model, observe Observer) Model {
+	return Model{
+		URLS:      ObservedURLModel{URLStore: m.URLS, observe: observe},
+		Analytics: ObservedAnalyticsModel{AnalyticsStore: m.Analytics, observe: observe},
+		Tokens:    ObservedTokenModel{TokenStore: m.Tokens, observe: observe},
+		Users:     ObservedUserModel{UserStore: m.Users, observe: observe},
+		Privacy:   ObservedPrivacyModel{PrivacyStore: m.Privacy, observe: observe},
+		Domains:   ObservedDomainModel{DomainStore: m.Domains, observe: observe},
+	}
+}
+
+// ObservedURLModel reports the calls made to a URLStore.
+type ObservedURLModel struct {
+	URLStore
+	observe Observer
+}
+
+func (m ObservedURLModel) Insert(ctx context.Context, url *URL) error {
+	ctx, done := m.observe(ctx, "urls", "insert")
+	err := m.URLStore.Insert(ctx, url)
+	done(err)
+	return err
+}
+
+func (m ObservedURLModel) GetByShort(ctx context.Context, domain string, shortCode string) (*URL, error) {
+	ctx, done := m.observe(ctx, "urls", "get_by_short")
+	result, err := m.URLStore.GetByShort(ctx, domain, shortCode)
+	done(err)
+	return result, err
+}
+
+func (m ObservedURLModel) GetAllForUser(ctx context.Context, userID int64) ([]*URL, error) {
+	ctx, done := m.observe(ctx, "urls", "get_all_for_user")
+	result, err := m.URLStore.GetAllForUser(ctx, userID)
+	done(err)
+	return result, err
+}
+
+func (m ObservedURLModel) DeleteByShort(ctx context.Context, domain string, shortCode string) error {
+	ctx, done := m.observe(ctx, "urls", "delete_by_short")
+	err := m.URLStore.DeleteByShort(ctx, domain, shortCode)
+	done(err)
+	return err
+}
+
+func (m ObservedURLModel) Update(ctx context.Context, url *URL) error {
+	ctx, done := m.observe(ctx, "urls", "update")
+	err := m.URLStore.Update(ctx, url)
+	done(err)
+	return err
+}
+
+func (m ObservedURLModel) GetByLongURL(ctx context.Context, domain string, longURL string, redirectType int, userID int64) (*URL, error) {
+	ctx, done := m.observe(ctx, "urls", "get_by_long_url")
+	result, err := m.URLStore.GetByLongURL(ctx, domain, longURL, redirectType, userID)
+	done(err)
+	return result, err
+}
+
+func (m ObservedURLModel) DeleteExpired(ctx context.Context, cutoff time.Time) ([]*URL, error) {
+	ctx, done := m.observe(ctx, "urls", "delete_expired")
+	result, err := m.URLStore.DeleteExpired(ctx, cutoff)
+	done(err)
+	return result, err
+}
+
+func (m ObservedURLModel) DeleteByDomain(ctx context.Context, domain string) ([]*URL, error) {
+	ctx, done := m.observe(ctx, "urls", "delete_by_domain")
+	result, err := m.URLStore.DeleteByDomain(ctx, domain)
+	done(err)
+	return result, err
+}
+
+// ObservedAnalyticsModel reports the calls made to a AnalyticsStore.
+type ObservedAnalyticsModel struct {
+	AnalyticsStore
+	observe Observer
+}
+
+func (m ObservedAnalyticsModel) Insert(ctx context.Context, entry *AnalyticsEntry) error {
+	ctx, done := m.observe(ctx, "analytics", "insert")
+	err := m.AnalyticsStore.Insert(ctx, entry)
+	done(err)
+	return err
+}
+
+func (m ObservedAnalyticsModel) InsertBatch(ctx context.Context, entries []*AnalyticsEntry) error {
+	ctx, done := m.observe(ctx, "analytics", "insert_batch")
+	err := m.AnalyticsStore.InsertBatch(ctx, entries)
+	done(err)
+	return err
+}
+
+func (m ObservedAnalyticsModel) GetByURLID(ctx context.Context, urlID int64) ([]*AnalyticsEntry, error) {
+	ctx, done := m.observe(ctx, "analytics", "get_by_url_id")
+	result, err := m.AnalyticsStore.GetByURLID(ctx, urlID)
+	done(err)
+	return result, err
+}
+
+func (m ObservedAnalyticsModel) Summarize(ctx context.Context, urlID int64, filter AnalyticsFilter) (*AnalyticsSummary, error) {
+	ctx, done := m.observe(ctx, "analytics", "summarize")
+	result, err := m.AnalyticsStore.Summarize(ctx, urlID, filter)
+	done(err)
+	return result, err
+}
+
+func (m ObservedAnalyticsModel) Stream(ctx context.Context, urlID int64, from, to time.Time, fn func(entry *AnalyticsEntry) error) error {
+	ctx, done := m.observe(ctx, "analytics", "stream")
+	err := m.AnalyticsStore.Stream(ctx, urlID, from, to, fn)
+	done(err)
+	return err
+}
+
+func (m ObservedAnalyticsModel) GetWithoutAgentDetails(ctx context.Context, afterID int64, limit int) ([]*AnalyticsEntry, error) {
+	ctx, done := m.observe(ctx, "analytics", "get_without_agent_details")
+	result, err := m.AnalyticsStore.GetWithoutAgentDetails(ctx, afterID, limit)
+	done(err)
+	return result, err
+}
+
+func (m ObservedAnalyticsModel) SetAgentDetails(ctx context.Context, entries []*AnalyticsEntry) error {
+	ctx, done := m.observe(ctx, "analytics", "set_agent_details")
+	err := m.AnalyticsStore.SetAgentDetails(ctx, entries)
+	done(err)
+	return err
+}
+
+func (m ObservedAnalyticsModel) DeleteOlderThan(ctx context.Context, cutoff time.Time, except []int64) (int64, error) {
+	ctx, done := m.observe(ctx, "analytics", "delete_older_than")
+	result, err := m.AnalyticsStore.DeleteOlderThan(ctx, cutoff, except)
+	done(err)
+	return result, err
+}
+
+func (m ObservedAnalyticsModel) DeleteOlderThanForUser(ctx context.Context, userID int64, cutoff time.Time) (int64, error) {
+	ctx, done := m.observe(ctx, "analytics", "delete_older_than_for_user")
+	result, err := m.AnalyticsStore.DeleteOlderThanForUser(ctx, userID, cutoff)
+	done(err)
+	return result, err
+}
+
+func (m ObservedAnalyticsModel) DeleteByURLID(ctx context.Context, urlID int64) error {
+	ctx, done := m.observe(ctx, "analytics", "delete_by_url_id")
+	err := m.AnalyticsStore.DeleteByURLID(ctx, urlID)
+	done(err)
+	return err
+}
+
+// ObservedUserModel reports the calls made to a UserStore.
+type ObservedUserModel struct {
+	UserStore
+	observe Observer
+}
+
+func (m ObservedUserModel) Insert(ctx context.Context, user *User) error {
+	ctx, done := m.observe(ctx, "users", "insert")
+	err := m.UserStore.Insert(ctx, user)
+	done(err)
+	return err
+}
+
+func (m ObservedUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, done := m.observe(ctx, "users", "get_by_email")
+	result, err := m.UserStore.GetByEmail(ctx, email)
+	done(err)
+	return result, err
+}
+
+func (m ObservedUserModel) SetUserType(ctx context.Context, userID int64, userType int) error {
+	ctx, done := m.observe(ctx, "users", "set_user_type")
+	err := m.UserStore.SetUserType(ctx, userID, userType)
+	done(err)
+	return err
+}
+
+func (m ObservedUserModel) SetEmail(ctx context.Context, userID int64, email string) error {
+	ctx, done := m.observe(ctx, "users", "set_email")
+	err := m.UserStore.SetEmail(ctx, userID, email)
+	done(err)
+	return err
+}
+
+func (m ObservedUserModel) SetPassword(ctx context.Context, userID int64, passwordHash string) error {
+	ctx, done := m.observe(ctx, "users", "set_password")
+	err := m.UserStore.SetPassword(ctx, userID, passwordHash)
+	done(err)
+	return err
+}
+
+func (m ObservedUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	ctx, done := m.observe(ctx, "users", "get_for_token")
+	result, err := m.UserStore.GetForToken(ctx, tokenScope, tokenPlaintext)
+	done(err)
+	return result, err
+}
+
+func (m ObservedUserModel) Delete(ctx context.Context, userID int64) error {
+	ctx, done := m.observe(ctx, "users", "delete")
+	err := m.UserStore.Delete(ctx, userID)
+	done(err)
+	return err
+}
+
+// ObservedTokenModel reports the calls made to a TokenStore.
+type ObservedTokenModel struct {
+	TokenStore
+	observe Observer
+}
+
+func (m ObservedTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	ctx, done := m.observe(ctx, "tokens", "new")
+	result, err := m.TokenStore.New(ctx, userID, ttl, scope)
+	done(err)
+	return result, err
+}
+
+func (m ObservedTokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, done := m.observe(ctx, "tokens", "insert")
+	err := m.TokenStore.Insert(ctx, token)
+	done(err)
+	return err
+}
+
+func (m ObservedTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	ctx, done := m.observe(ctx, "tokens", "delete_all_for_user")
+	err := m.TokenStore.DeleteAllForUser(ctx, scope, userID)
+	done(err)
+	return err
+}
+
+func (m ObservedTokenModel) DeleteOneForUser(ctx context.Context, tokenPlaintext string) error {
+	ctx, done := m.observe(ctx, "tokens", "delete_one_for_user")
+	err := m.TokenStore.DeleteOneForUser(ctx, tokenPlaintext)
+	done(err)
+	return err
+}
+
+func (m ObservedTokenModel) DeleteExpired(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, done := m.observe(ctx, "tokens", "delete_expired")
+	result, err := m.TokenStore.DeleteExpired(ctx, cutoff)
+	done(err)
+	return result, err
+}
+
+// ObservedPrivacyModel reports the calls made to a PrivacyStore.
+type ObservedPrivacyModel struct {
+	PrivacyStore
+	observe Observer
+}
+
+func (m ObservedPrivacyModel) Get(ctx context.Context, userID int64) (*PrivacySettings, error) {
+	ctx, done := m.observe(ctx, "privacy", "get")
+	result, err := m.PrivacyStore.Get(ctx, userID)
+	done(err)
+	return result, err
+}
+
+func (m ObservedPrivacyModel) GetAll(ctx context.Context) ([]*PrivacySettings, error) {
+	ctx, done := m.observe(ctx, "privacy", "get_all")
+	result, err := m.PrivacyStore.GetAll(ctx)
+	done(err)
+	return result, err
+}
+
+func (m ObservedPrivacyModel) Set(ctx context.Context, settings *PrivacySettings) error {
+	ctx, done := m.observe(ctx, "privacy", "set")
+	err := m.PrivacyStore.Set(ctx, settings)
+	done(err)
+	return err
+}
+
+// ObservedDomainModel reports the calls made to a DomainStore.
+type ObservedDomainModel struct {
+	DomainStore
+	observe Observer
+}
+
+func (m ObservedDomainModel) Insert(ctx context.Context, domain *Domain) error {
+	ctx, done := m.observe(ctx, "domains", "insert")
+	err := m.DomainStore.Insert(ctx, domain)
+	done(err)
+	return err
+}
+
+func (m ObservedDomainModel) Get(ctx context.Context, userID int64, host string) (*Domain, error) {
+	ctx, done := m.observe(ctx, "domains", "get")
+	result, err := m.DomainStore.Get(ctx, userID, host)
+	done(err)
+	return result, err
+}
+
+func (m ObservedDomainModel) GetVerified(ctx context.Context, host string) (*Domain, error) {
+	ctx, done := m.observe(ctx, "domains", "get_verified")
+	result, err := m.DomainStore.GetVerified(ctx, host)
+	done(err)
+	return result, err
+}
+
+func (m ObservedDomainModel) GetAllForUser(ctx context.Context, userID int64) ([]*Domain, error) {
+	ctx, done := m.observe(ctx, "domains", "get_all_for_user")
+	result, err := m.DomainStore.GetAllForUser(ctx, userID)
+	done(err)
+	return result, err
+}
+
+func (m ObservedDomainModel) MarkVerified(ctx context.Context, domain *Domain) error {
+	ctx, done := m.observe(ctx, "domains", "mark_verified")
+	err := m.DomainStore.MarkVerified(ctx, domain)
+	done(err)
+	return err
+}
+
+func (m ObservedDomainModel) Delete(ctx context.Context, userID int64, host string) error {
+	ctx, done := m.observe(ctx, "domains", "delete")
+	err := m.DomainStore.Delete(ctx, userID, host)
+	done(err)
+	return err
+}
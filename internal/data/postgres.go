@@ -0,0 +1,741 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NewPostgresModel returns a Model backed by the given PostgreSQL database.
+func NewPostgresModel(db *sql.DB) Model {
+	return Model{
+		URLS:      &PostgresURLModel{DB: db},
+		Analytics: &PostgresAnalyticsModel{DB: db},
+		Tokens:    PostgresTokenModel{DB: db},
+		Users:     PostgresUserModel{DB: db},
+		Privacy:   PostgresPrivacyModel{DB: db},
+		Domains:   PostgresDomainModel{DB: db},
+	}
+}
+
+// postgresError maps a unique constraint violation reported by PostgreSQL to
+// dup and returns any other error unchanged.
+func postgresError(err error, dup error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return dup
+	}
+	return err
+}
+
+// PostgresURLModel is the PostgreSQL implementation of URLStore.
+type PostgresURLModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts a new URL record into the database.
+func (model *PostgresURLModel) Insert(ctx context.Context, url *URL) error {
+	query := `
+		INSERT INTO urls (long_url, short_url, domain, redirect, user_id, created, expired, once) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+		RETURNING id;
+	`
+
+	err := model.DB.QueryRowContext(ctx, query, url.LongForm, url.ShortCode, url.Domain, url.Redirect, url.UserID, url.Created, url.Expired, url.Once).Scan(&url.ID)
+	if err != nil {
+		return postgresError(err, ErrDuplicateEntry)
+	}
+
+	return nil
+}
+
+// GetByShort retrieves a URL record based on its domain and short code.
+func (model *PostgresURLModel) GetByShort(ctx context.Context, domain string, shortCode string) (*URL, error) {
+	query := `
+		SELECT id, long_url, redirect, user_id, created, expired, once FROM urls WHERE domain = $1 AND short_url = $2;
+	`
+	row := model.DB.QueryRowContext(ctx, query, domain, shortCode)
+
+	url := &URL{
+		ShortCode: shortCode,
+		Domain:    domain,
+	}
+	err := row.Scan(&url.ID, &url.LongForm, &url.Redirect, &url.UserID, &url.Created, &url.Expired, &url.Once)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return url, nil
+}
+
+// GetAllForUser retrieves all urls by the user.
+func (model *PostgresURLModel) GetAllForUser(ctx context.Context, userID int64) ([]*URL, error) {
+	query := `
+		SELECT id, long_url, short_url, domain, redirect, user_id, created, expired, once FROM urls WHERE user_id = $1 ORDER BY id;
+	`
+	rows, err := model.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []*URL
+
+	for rows.Next() {
+		var url URL
+		err := rows.Scan(&url.ID, &url.LongForm, &url.ShortCode, &url.Domain, &url.Redirect, &url.UserID, &url.Created, &url.Expired, &url.Once)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, &url)
+	}
+
+	return urls, rows.Err()
+}
+
+// DeleteByShort deletes the URL with the given domain and short code.
+func (model *PostgresURLModel) DeleteByShort(ctx context.Context, domain string, shortCode string) error {
+	_, err := model.DB.ExecContext(ctx, `DELETE FROM urls WHERE domain = $1 AND short_url = $2;`, domain, shortCode)
+	return err
+}
+
+// DeleteExpired deletes the URLs that expired before cutoff and returns them.
+// Their analytics go with them through the foreign key's ON DELETE CASCADE.
+func (model *PostgresURLModel) DeleteExpired(ctx context.Context, cutoff time.Time) ([]*URL, error) {
+	rows, err := model.DB.QueryContext(ctx, `DELETE FROM urls WHERE expired < $1 RETURNING id, short_url, domain, user_id;`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []*URL
+	for rows.Next() {
+		var url URL
+		err := rows.Scan(&url.ID, &url.ShortCode, &url.Domain, &url.UserID)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, &url)
+	}
+
+	return urls, rows.Err()
+}
+
+// DeleteByDomain deletes every URL on domain and returns them. Their analytics
+// go with them through the foreign key's ON DELETE CASCADE.
+func (model *PostgresURLModel) DeleteByDomain(ctx context.Context, domain string) ([]*URL, error) {
+	rows, err := model.DB.QueryContext(ctx, `DELETE FROM urls WHERE domain = $1 RETURNING id, short_url, domain, user_id;`, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []*URL
+	for rows.Next() {
+		var url URL
+		err := rows.Scan(&url.ID, &url.ShortCode, &url.Domain, &url.UserID)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, &url)
+	}
+
+	return urls, rows.Err()
+}
+
+// Update modifies an existing URL record in the database.
+func (model *PostgresURLModel) Update(ctx context.Context, url *URL) error {
+	query := `
+		UPDATE urls
+		SET long_url = $1, short_url = $2, redirect = $3, user_id = $4, expired = $5, once = $6
+		WHERE id = $7;
+	`
+
+	_, err := model.DB.ExecContext(ctx, query, url.LongForm, url.ShortCode, url.Redirect, url.UserID, url.Expired, url.Once, url.ID)
+	if err != nil {
+		return postgresError(err, ErrDuplicateEntry)
+	}
+
+	return nil
+}
+
+// GetByLongURL retrieves a URL record on domain based on the long URL.
+func (model *PostgresURLModel) GetByLongURL(ctx context.Context, domain string, longURL string, redirectType int, userID int64) (*URL, error) {
+	query := `
+		SELECT id, long_url, short_url, redirect, created, expired, once FROM urls WHERE domain = $1 AND long_url = $2 AND redirect = $3 AND user_id = $4
+		ORDER BY id LIMIT 1;
+	`
+	row := model.DB.QueryRowContext(ctx, query, domain, longURL, redirectType, userID)
+
+	url := &URL{
+		UserID: userID,
+		Domain: domain,
+	}
+	err := row.Scan(&url.ID, &url.LongForm, &url.ShortCode, &url.Redirect, &url.Created, &url.Expired, &url.Once)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return url, nil
+}
+
+// PostgresAnalyticsModel is the PostgreSQL implementation of AnalyticsStore.
+type PostgresAnalyticsModel struct {
+	DB *sql.DB
+}
+
+const postgresInsertAnalytics = `
+			INSERT INTO analytics (url_id, ip, user_agent, referrer, timestamp, browser, browser_version, os, device, country, region, city, bot)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+// Insert adds a new analytics entry into the database.
+func (model *PostgresAnalyticsModel) Insert(ctx context.Context, entry *AnalyticsEntry) error {
+	return model.DB.QueryRowContext(ctx, postgresInsertAnalytics+` RETURNING id`, entry.insertArgs()...).Scan(&entry.ID)
+}
+
+// InsertBatch adds several analytics entries in a single transaction.
+func (model *PostgresAnalyticsModel) InsertBatch(ctx context.Context, entries []*AnalyticsEntry) error {
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, postgresInsertAnalytics)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		_, err = stmt.ExecContext(ctx, entry.insertArgs()...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetByURLID retrieves analytics entries for a specific short URL from the database.
+func (model *PostgresAnalyticsModel) GetByURLID(ctx context.Context, urlID int64) ([]*AnalyticsEntry, error) {
+	query := `
+			SELECT id, url_id, ip, user_agent, COALESCE(referrer, ''), timestamp, browser, browser_version, os, device, country, region, city, bot
+			FROM analytics
+			WHERE url_id = $1
+			ORDER BY id;
+	`
+	rows, err := model.DB.QueryContext(ctx, query, urlID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var analytics []*AnalyticsEntry
+
+	err = scanEntries(rows, func(entry *AnalyticsEntry) error {
+		analytics = append(analytics, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return analytics, nil
+}
+
+// Stream calls fn for every entry of a short URL recorded in [from, to), in
+// the order they were recorded, reading rows from the cursor one at a time.
+func (model *PostgresAnalyticsModel) Stream(ctx context.Context, urlID int64, from, to time.Time, fn func(entry *AnalyticsEntry) error) error {
+	query := `
+			SELECT id, url_id, ip, user_agent, COALESCE(referrer, ''), timestamp, browser, browser_version, os, device, country, region, city, bot
+			FROM analytics
+			WHERE url_id = $1 AND timestamp >= $2 AND timestamp < $3
+			ORDER BY id;
+	`
+	rows, err := model.DB.QueryContext(ctx, query, urlID, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	return scanEntries(rows, fn)
+}
+
+// GetWithoutAgentDetails returns up to limit entries, ordered by id and starting
+// after afterID, whose user agent has not been parsed yet.
+func (model *PostgresAnalyticsModel) GetWithoutAgentDetails(ctx context.Context, afterID int64, limit int) ([]*AnalyticsEntry, error) {
+	query := `
+			SELECT id, user_agent
+			FROM analytics
+			WHERE device = '' AND id > $1
+			ORDER BY id
+			LIMIT $2;
+	`
+	rows, err := model.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var analytics []*AnalyticsEntry
+
+	for rows.Next() {
+		var entry AnalyticsEntry
+		err := rows.Scan(&entry.ID, &entry.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		analytics = append(analytics, &entry)
+	}
+
+	return analytics, rows.Err()
+}
+
+// SetAgentDetails stores the parsed user agent fields of the given entries in a
+// single transaction. Entries already flagged as bots stay flagged.
+func (model *PostgresAnalyticsModel) SetAgentDetails(ctx context.Context, entries []*AnalyticsEntry) error {
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE analytics SET browser = $1, browser_version = $2, os = $3, device = $4, bot = (bot OR $5) WHERE id = $6;`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		_, err = stmt.ExecContext(ctx, entry.Browser, entry.BrowserVersion, entry.OS, entry.Device, entry.Bot, entry.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Summarize aggregates the clicks on a short URL that match filter.
+func (model *PostgresAnalyticsModel) Summarize(ctx context.Context, urlID int64, filter AnalyticsFilter) (*AnalyticsSummary, error) {
+	summary := &AnalyticsSummary{
+		From:        filter.From,
+		To:          filter.To,
+		Interval:    filter.Interval,
+		IncludeBots: filter.IncludeBots,
+	}
+	where := `WHERE url_id = $1 AND timestamp >= $2 AND timestamp < $3`
+
+	err := model.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM analytics `+where+` AND bot`, urlID, filter.From, filter.To).Scan(&summary.BotClicks)
+	if err != nil {
+		return nil, err
+	}
+	if !filter.IncludeBots {
+		where += ` AND NOT bot`
+	}
+
+	err = model.DB.QueryRowContext(ctx, `SELECT COUNT(*), COUNT(DISTINCT ip) FROM analytics `+where, urlID, filter.From, filter.To).Scan(&summary.TotalClicks, &summary.UniqueVisitors)
+	if err != nil {
+		return nil, err
+	}
+
+	// date_trunc('week', ...) starts weeks on Monday, matching bucketStart.
+	buckets, err := scanSeries(ctx, model.DB, `
+		SELECT EXTRACT(EPOCH FROM date_trunc($4, timestamp AT TIME ZONE 'UTC'))::BIGINT AS bucket, COUNT(*), COUNT(DISTINCT ip)
+		FROM analytics `+where+`
+		GROUP BY bucket`, urlID, filter.From, filter.To, filter.Interval)
+	if err != nil {
+		return nil, err
+	}
+	summary.Series = fillSeries(filter, buckets)
+
+	for _, breakdown := range summary.breakdowns() {
+		*breakdown.dst, err = scanCounts(ctx, model.DB, `
+			SELECT `+breakdown.column+`, COUNT(*) AS clicks FROM analytics `+where+`
+			GROUP BY 1 ORDER BY clicks DESC, 1 LIMIT $4`, urlID, filter.From, filter.To, filter.Top)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
+
+// DeleteOlderThan deletes entries recorded before cutoff, except those on URLs
+// owned by the users in except, who have their own retention window.
+func (model *PostgresAnalyticsModel) DeleteOlderThan(ctx context.Context, cutoff time.Time, except []int64) (int64, error) {
+	query := `
+		DELETE FROM analytics
+		WHERE timestamp < $1 AND url_id NOT IN (SELECT id FROM urls WHERE user_id = ANY($2))`
+
+	res, err := model.DB.ExecContext(ctx, query, cutoff, pq.Array(except))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteOlderThanForUser deletes entries recorded before cutoff on URLs owned by userID.
+func (model *PostgresAnalyticsModel) DeleteOlderThanForUser(ctx context.Context, userID int64, cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM analytics
+		WHERE timestamp < $1 AND url_id IN (SELECT id FROM urls WHERE user_id = $2)`
+
+	res, err := model.DB.ExecContext(ctx, query, cutoff, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (model *PostgresAnalyticsModel) DeleteByURLID(ctx context.Context, urlID int64) error {
+	_, err := model.DB.ExecContext(ctx, "DELETE FROM analytics WHERE url_id = $1", urlID)
+	return err
+}
+
+// PostgresUserModel is the PostgreSQL implementation of UserStore.
+type PostgresUserModel struct {
+	DB *sql.DB
+}
+
+func (m PostgresUserModel) Insert(ctx context.Context, user *User) error {
+	query := `
+	INSERT INTO users (name, email, password_hash, type, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id
+	`
+	curTime := time.Now()
+	args := []interface{}{user.Username, user.Email, user.Password.Hash, user.Type, curTime}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID)
+	if err != nil {
+		return postgresError(err, ErrDuplicateEmail)
+	}
+	user.CreatedAt = curTime
+
+	return nil
+}
+
+func (m PostgresUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, type
+		FROM users
+		WHERE email = $1`
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Username,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Type,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m PostgresUserModel) SetUserType(ctx context.Context, userID int64, userType int) error {
+	_, err := m.DB.ExecContext(ctx, `UPDATE users SET type = $1 WHERE id = $2`, userType, userID)
+	return err
+}
+
+func (m PostgresUserModel) SetEmail(ctx context.Context, userID int64, email string) error {
+	_, err := m.DB.ExecContext(ctx, `UPDATE users SET email = $1 WHERE id = $2`, email, userID)
+	return postgresError(err, ErrDuplicateEmail)
+}
+
+func (m PostgresUserModel) SetPassword(ctx context.Context, userID int64, passwordHash string) error {
+	_, err := m.DB.ExecContext(ctx, `UPDATE users SET password_hash = $1 WHERE id = $2`, []byte(passwordHash), userID)
+	return err
+}
+
+func (m PostgresUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.type
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3`
+
+	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Username,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Type,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Delete removes a user along with their short URLs and their analytics. The
+// rest of the account's rows go through ON DELETE CASCADE.
+func (m PostgresUserModel) Delete(ctx context.Context, userID int64) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM urls WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// PostgresTokenModel is the PostgreSQL implementation of TokenStore.
+type PostgresTokenModel struct {
+	DB *sql.DB
+}
+
+func (m PostgresTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m PostgresTokenModel) Insert(ctx context.Context, token *Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err := m.DB.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	return err
+}
+
+func (m PostgresTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`, scope, userID)
+	return err
+}
+
+// DeleteExpired deletes every token that expired before cutoff and returns how many were deleted.
+func (m PostgresTokenModel) DeleteExpired(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE expiry < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (m PostgresTokenModel) DeleteOneForUser(ctx context.Context, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE hash = $1`, tokenHash[:])
+	return err
+}
+
+// PostgresPrivacyModel is the PostgreSQL implementation of PrivacyStore.
+type PostgresPrivacyModel struct {
+	DB *sql.DB
+}
+
+// Get returns the settings of a user, or empty settings when they have none.
+func (m PostgresPrivacyModel) Get(ctx context.Context, userID int64) (*PrivacySettings, error) {
+	settings := &PrivacySettings{UserID: userID}
+	err := m.DB.QueryRowContext(ctx, `SELECT ip_mode, retention_days FROM privacy_settings WHERE user_id = $1`, userID).
+		Scan(&settings.IPMode, &settings.RetentionDays)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GetAll returns the settings of every user who has overridden a default.
+func (m PostgresPrivacyModel) GetAll(ctx context.Context) ([]*PrivacySettings, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT user_id, ip_mode, retention_days FROM privacy_settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []*PrivacySettings
+	for rows.Next() {
+		var settings PrivacySettings
+		err := rows.Scan(&settings.UserID, &settings.IPMode, &settings.RetentionDays)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, &settings)
+	}
+
+	return all, rows.Err()
+}
+
+// Set stores the settings of a user, replacing any previous ones.
+func (m PostgresPrivacyModel) Set(ctx context.Context, settings *PrivacySettings) error {
+	query := `
+		INSERT INTO privacy_settings (user_id, ip_mode, retention_days)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET ip_mode = EXCLUDED.ip_mode, retention_days = EXCLUDED.retention_days`
+
+	_, err := m.DB.ExecContext(ctx, query, settings.UserID, settings.IPMode, settings.RetentionDays)
+	return err
+}
+
+// PostgresDomainModel is the PostgreSQL implementation of DomainStore.
+type PostgresDomainModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a claim on a domain, rejecting hosts the user already claimed.
+func (m PostgresDomainModel) Insert(ctx context.Context, domain *Domain) error {
+	query := `
+		INSERT INTO domains (user_id, host, verification_token, verified, created)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+
+	err := m.DB.QueryRowContext(ctx, query, domain.UserID, domain.Host, domain.Token, domain.Verified, domain.Created).Scan(&domain.ID)
+	return postgresError(err, ErrDuplicateEntry)
+}
+
+// Get returns a user's claim on host.
+func (m PostgresDomainModel) Get(ctx context.Context, userID int64, host string) (*Domain, error) {
+	query := `
+		SELECT id, user_id, host, verification_token, verified, created
+		FROM domains
+		WHERE user_id = $1 AND host = $2`
+
+	return m.scanOne(m.DB.QueryRowContext(ctx, query, userID, host))
+}
+
+// GetVerified returns the verified claim on host.
+func (m PostgresDomainModel) GetVerified(ctx context.Context, host string) (*Domain, error) {
+	query := `
+		SELECT id, user_id, host, verification_token, verified, created
+		FROM domains
+		WHERE host = $1 AND verified`
+
+	return m.scanOne(m.DB.QueryRowContext(ctx, query, host))
+}
+
+func (m PostgresDomainModel) scanOne(row *sql.Row) (*Domain, error) {
+	var domain Domain
+	err := row.Scan(&domain.ID, &domain.UserID, &domain.Host, &domain.Token, &domain.Verified, &domain.Created)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// GetAllForUser returns the domains claimed by a user.
+func (m PostgresDomainModel) GetAllForUser(ctx context.Context, userID int64) ([]*Domain, error) {
+	query := `
+		SELECT id, user_id, host, verification_token, verified, created
+		FROM domains
+		WHERE user_id = $1
+		ORDER BY host`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []*Domain{}
+	for rows.Next() {
+		var domain Domain
+		err := rows.Scan(&domain.ID, &domain.UserID, &domain.Host, &domain.Token, &domain.Verified, &domain.Created)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, &domain)
+	}
+	return domains, rows.Err()
+}
+
+// MarkVerified marks a claim as verified and drops the other users' unverified
+// claims on the host. It returns ErrDuplicateEntry when another user already
+// verified the host.
+func (m PostgresDomainModel) MarkVerified(ctx context.Context, domain *Domain) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE domains SET verified = true WHERE id = $1`, domain.ID)
+	if err != nil {
+		return postgresError(err, ErrDuplicateEntry)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM domains WHERE host = $1 AND id <> $2 AND NOT verified`, domain.Host, domain.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	domain.Verified = true
+	return nil
+}
+
+// Delete removes a user's claim on a domain, returning ErrRecordNotFound when they have none.
+func (m PostgresDomainModel) Delete(ctx context.Context, userID int64, host string) error {
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM domains WHERE user_id = $1 AND host = $2`, userID, host)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
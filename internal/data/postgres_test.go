@@ -0,0 +1,290 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+	"url_shortner/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/lib/pq"
+)
+
+// newPostgresTestModel migrates a schema of its own in the database named by
+// CHOPPER_TEST_POSTGRES_DSN, a postgres:// URL, and returns the stores backed
+// by it, along with the connection for checks the stores cannot make. The
+// schema is dropped when the test ends. Without the variable the test is skipped.
+func newPostgresTestModel(t *testing.T) (Model, *sql.DB) {
+	t.Helper()
+
+	dsn := os.Getenv("CHOPPER_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("CHOPPER_TEST_POSTGRES_DSN is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("chopper_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	// Every connection works in the new schema, and in a time zone other than
+	// UTC so that bucketing cannot lean on the server default.
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	q.Set("timezone", "Asia/Kolkata")
+	u.RawQuery = q.Encode()
+
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	files, err := fs.Sub(migrations.FS, "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := iofs.New(files, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err := postgres.WithInstance(db, &postgres.Config{SchemaName: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewPostgresModel(db), db
+}
+
+func insertTestUser(t *testing.T, model Model, email string) *User {
+	t.Helper()
+
+	user := &User{Username: "test", Email: email, Type: 2}
+	err := user.Password.Set("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = model.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func insertTestURL(t *testing.T, model Model, user *User, shortCode string) *URL {
+	t.Helper()
+
+	url := NewURL("", "https://example.com/"+shortCode, shortCode, 308, user, false)
+	err := model.URLS.Insert(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url
+}
+
+func TestPostgresDuplicates(t *testing.T) {
+	model, _ := newPostgresTestModel(t)
+	ctx := context.Background()
+
+	user := insertTestUser(t, model, "dup@example.com")
+
+	again := &User{Username: "again", Email: "dup@example.com", Type: 1}
+	again.Password.Set("password123")
+	err := model.Users.Insert(ctx, again)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("inserting a taken email: got %v, want ErrDuplicateEmail", err)
+	}
+
+	other := insertTestUser(t, model, "other@example.com")
+	err = model.Users.SetEmail(ctx, other.ID, "dup@example.com")
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("changing to a taken email: got %v, want ErrDuplicateEmail", err)
+	}
+
+	insertTestURL(t, model, user, "taken")
+	err = model.URLS.Insert(ctx, NewURL("", "https://example.com/other", "taken", 308, user, false))
+	if !errors.Is(err, ErrDuplicateEntry) {
+		t.Errorf("inserting a taken short code: got %v, want ErrDuplicateEntry", err)
+	}
+
+	// The same code is free on another domain.
+	err = model.URLS.Insert(ctx, NewURL("go.example", "https://example.com/other", "taken", 308, user, false))
+	if err != nil {
+		t.Errorf("inserting a taken short code on another domain: %v", err)
+	}
+}
+
+func TestPostgresCascades(t *testing.T) {
+	model, db := newPostgresTestModel(t)
+	ctx := context.Background()
+
+	user := insertTestUser(t, model, "cascade@example.com")
+	token, err := model.Tokens.New(ctx, user.ID, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := insertTestURL(t, model, user, "gone")
+	err = model.Analytics.Insert(ctx, &AnalyticsEntry{URLID: url.ID, IP: "192.0.2.1", UserAgent: "test", Timestamp: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = model.URLS.DeleteByShort(ctx, "", "gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var analytics int
+	err = db.QueryRow(`SELECT COUNT(*) FROM analytics WHERE url_id = $1`, url.ID).Scan(&analytics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analytics != 0 {
+		t.Errorf("%d analytics entries left after deleting their URL, want 0", analytics)
+	}
+
+	// Deleted directly, so only the foreign key can remove the tokens.
+	_, err = db.Exec(`DELETE FROM users WHERE id = $1`, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens int
+	err = db.QueryRow(`SELECT COUNT(*) FROM tokens WHERE user_id = $1`, user.ID).Scan(&tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 0 {
+		t.Errorf("%d tokens left after deleting their user, want 0", tokens)
+	}
+	_, err = model.Users.GetForToken(ctx, ScopeAuthentication, token.Plaintext)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("looking up a deleted user's token: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestPostgresSummarize(t *testing.T) {
+	model, _ := newPostgresTestModel(t)
+	ctx := context.Background()
+
+	user := insertTestUser(t, model, "stats@example.com")
+	url := insertTestURL(t, model, user, "stats")
+
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	entries := []*AnalyticsEntry{
+		// 23:30 UTC is already the next day in the session's time zone.
+		{IP: "192.0.2.1", Referrer: "https://a.example", Browser: "Firefox", Timestamp: day.Add(23*time.Hour + 30*time.Minute)},
+		{IP: "192.0.2.2", Referrer: "https://a.example", Browser: "Chrome", Timestamp: day.Add(10 * time.Hour)},
+		{IP: "192.0.2.1", Referrer: "https://b.example", Browser: "Firefox", Timestamp: day.Add(34 * time.Hour)},
+		{IP: "192.0.2.3", Browser: "Googlebot", Bot: true, Timestamp: day.Add(11 * time.Hour)},
+		// Outside the range.
+		{IP: "192.0.2.4", Browser: "Firefox", Timestamp: day.Add(-time.Hour)},
+	}
+	for _, entry := range entries {
+		entry.URLID = url.ID
+		entry.UserAgent = entry.Browser
+	}
+	err := model.Analytics.InsertBatch(ctx, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := AnalyticsFilter{From: day, To: day.AddDate(0, 0, 3), Interval: IntervalDay, Top: 10}
+	summary, err := model.Analytics.Summarize(ctx, url.ID, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.TotalClicks != 3 || summary.UniqueVisitors != 2 || summary.BotClicks != 1 {
+		t.Errorf("got %d clicks from %d visitors and %d bot clicks, want 3 from 2 and 1",
+			summary.TotalClicks, summary.UniqueVisitors, summary.BotClicks)
+	}
+
+	want := []int64{2, 1, 0}
+	if len(summary.Series) != len(want) {
+		t.Fatalf("got %d daily buckets, want %d", len(summary.Series), len(want))
+	}
+	for i, bucket := range summary.Series {
+		start := day.AddDate(0, 0, i)
+		if !bucket.Start.Equal(start) || bucket.Clicks != want[i] {
+			t.Errorf("bucket %d: got %d clicks from %s, want %d from %s", i, bucket.Clicks, bucket.Start, want[i], start)
+		}
+	}
+
+	if len(summary.TopReferrers) == 0 || summary.TopReferrers[0] != (CountEntry{Value: "https://a.example", Clicks: 2}) {
+		t.Errorf("got top referrers %v, want https://a.example with 2 clicks first", summary.TopReferrers)
+	}
+
+	filter.IncludeBots = true
+	summary, err = model.Analytics.Summarize(ctx, url.ID, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.TotalClicks != 4 {
+		t.Errorf("got %d clicks with bots included, want 4", summary.TotalClicks)
+	}
+}
+
+func TestPostgresDeleteOlderThan(t *testing.T) {
+	model, _ := newPostgresTestModel(t)
+	ctx := context.Background()
+
+	kept := insertTestUser(t, model, "kept@example.com")
+	purged := insertTestUser(t, model, "purged@example.com")
+	keptURL := insertTestURL(t, model, kept, "kept")
+	purgedURL := insertTestURL(t, model, purged, "purged")
+
+	old := time.Now().AddDate(0, 0, -10)
+	err := model.Analytics.InsertBatch(ctx, []*AnalyticsEntry{
+		{URLID: keptURL.ID, IP: "192.0.2.1", UserAgent: "test", Timestamp: old},
+		{URLID: purgedURL.ID, IP: "192.0.2.1", UserAgent: "test", Timestamp: old},
+		{URLID: purgedURL.ID, IP: "192.0.2.1", UserAgent: "test", Timestamp: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := model.Analytics.DeleteOlderThan(ctx, time.Now().AddDate(0, 0, -1), []int64{kept.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d entries, want 1", deleted)
+	}
+
+	// No exceptions at all must not turn into an empty NOT IN matching nothing.
+	deleted, err = model.Analytics.DeleteOlderThan(ctx, time.Now().AddDate(0, 0, -1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d entries without exceptions, want 1", deleted)
+	}
+}
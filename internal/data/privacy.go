@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"url_shortner/internal/validator"
+)
+
+// How client IP addresses are stored with analytics entries, from least to most private.
+const (
+	IPModeNone     = "none"     // full address
+	IPModeTruncate = "truncate" // last IPv4 octet or everything past the IPv6 /48 zeroed
+	IPModeHash     = "hash"     // salted hash of the address
+)
+
+// PrivacySettings are a user's overrides of the deployment's privacy defaults.
+// Empty or zero fields inherit the deployment setting.
+type PrivacySettings struct {
+	UserID        int64  `json:"-"`
+	IPMode        string `json:"ip_mode"`
+	RetentionDays int    `json:"retention_days"`
+}
+
+// IPModeStrength orders IP modes by how much of the address they hide.
+func IPModeStrength(mode string) int {
+	switch mode {
+	case IPModeTruncate:
+		return 1
+	case IPModeHash:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func ValidatePrivacySettings(v *validator.Validator, settings *PrivacySettings) {
+	v.Check(settings.IPMode == "" || settings.IPMode == IPModeNone || settings.IPMode == IPModeTruncate || settings.IPMode == IPModeHash,
+		"ip_mode", "must be one of 'none', 'truncate' or 'hash'")
+	v.Check(settings.RetentionDays >= 0, "retention_days", "must not be negative")
+	v.Check(settings.RetentionDays <= 3650, "retention_days", "must not be more than 3650 days")
+}
+
+// PrivacyModel is the SQLite implementation of PrivacyStore.
+type PrivacyModel struct {
+	DB *sql.DB
+}
+
+// Get returns the settings of a user, or empty settings when they have none.
+func (m PrivacyModel) Get(ctx context.Context, userID int64) (*PrivacySettings, error) {
+	query := `
+		SELECT ip_mode, retention_days
+		FROM privacy_settings
+		WHERE user_id = ?`
+
+	settings := &PrivacySettings{UserID: userID}
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&settings.IPMode, &settings.RetentionDays)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GetAll returns the settings of every user who has overridden a default.
+func (m PrivacyModel) GetAll(ctx context.Context) ([]*PrivacySettings, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT user_id, ip_mode, retention_days FROM privacy_settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []*PrivacySettings
+	for rows.Next() {
+		var settings PrivacySettings
+		err := rows.Scan(&settings.UserID, &settings.IPMode, &settings.RetentionDays)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, &settings)
+	}
+
+	return all, rows.Err()
+}
+
+// Set stores the settings of a user, replacing any previous ones.
+func (m PrivacyModel) Set(ctx context.Context, settings *PrivacySettings) error {
+	query := `
+		INSERT INTO privacy_settings (user_id, ip_mode, retention_days)
+		VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET ip_mode = excluded.ip_mode, retention_days = excluded.retention_days`
+
+	_, err := m.DB.ExecContext(ctx, query, settings.UserID, settings.IPMode, settings.RetentionDays)
+	return err
+}
@@ -0,0 +1,17 @@
+package data
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteError maps a constraint violation reported by SQLite to dup and
+// returns any other error unchanged.
+func sqliteError(err error, dup error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		return dup
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// WithQueryTimeout returns an Observer giving each store call at most timeout
+// before handing it to next. Streams are left to their caller's context, as
+// they last for as long as the caller takes to consume them.
+func WithQueryTimeout(timeout time.Duration, next Observer) Observer {
+	return func(ctx context.Context, store string, operation string) (context.Context, func(err error)) {
+		if operation == "stream" {
+			return next(ctx, store, operation)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		ctx, done := next(ctx, store, operation)
+		return ctx, func(err error) {
+			done(err)
+			cancel()
+		}
+	}
+}
+
+// IsTimeout reports whether err means a query ran out of time. PostgreSQL
+// reports a query canceled through its context as a server error rather than
+// the context's.
+func IsTimeout(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "57014"
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsUnavailable reports whether err means the database could not take the
+// query right now, so the same call may succeed later: SQLite kept its file
+// locked past the busy timeout, or PostgreSQL is out of connections or
+// shutting down.
+func IsUnavailable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Class() == "53" || (pqErr.Code.Class() == "57" && pqErr.Code != "57014")
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
@@ -49,46 +50,56 @@ func ValidateTokenPlainText(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
+// TokenModel is the SQLite implementation of TokenStore.
 type TokenModel struct {
 	DB *sql.DB
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
 		INSERT INTO tokens(hash,user_id,expiry,scope)
 		VALUES (?,?,?,?)`
 
 	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
 
-	_, err := m.DB.Exec(query, args...)
+	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = ? AND user_id = ?`
 
-	_, err := m.DB.Exec(query, scope, userID)
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
 
-func (m TokenModel) DeleteOneForUser(tokenPlaintext string) error {
+// DeleteExpired deletes every token that expired before cutoff and returns how many were deleted.
+func (m TokenModel) DeleteExpired(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE CAST(strftime('%s', expiry) AS INTEGER) < ?`, cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (m TokenModel) DeleteOneForUser(ctx context.Context, tokenPlaintext string) error {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	query := `
 	DELETE FROM tokens
 	WHERE hash = ?`
 
-	_, err := m.DB.Exec(query, tokenHash[:])
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:])
 	return err
 }
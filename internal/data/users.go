@@ -1,13 +1,13 @@
 package data
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"time"
 	"url_shortner/internal/validator"
 
-	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -85,11 +85,12 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 }
 
+// UserModel is the SQLite implementation of UserStore.
 type UserModel struct {
 	DB *sql.DB
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 
 	query := `
 	INSERT INTO users (name,email,password_hash,type,created_at) 
@@ -98,15 +99,9 @@ func (m UserModel) Insert(user *User) error {
 	curTime := time.Now()
 	args := []interface{}{user.Username, user.Email, user.Password.Hash, user.Type, curTime}
 
-	result, err := m.DB.Exec(query, args...)
+	result, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
-		sqliteErr, ok := err.(sqlite3.Error)
-		if ok {
-			if sqliteErr.Code == sqlite3.ErrConstraint {
-				return ErrDuplicateEmail
-			}
-		}
-		return err
+		return sqliteError(err, ErrDuplicateEmail)
 	}
 	user.ID, _ = result.LastInsertId()
 	user.CreatedAt = curTime
@@ -114,7 +109,7 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, type
 		FROM users
@@ -122,7 +117,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	err := m.DB.QueryRow(query, email).Scan(
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Username,
@@ -143,7 +138,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) SetUserType(userID int64, userType int) error {
+func (m UserModel) SetUserType(ctx context.Context, userID int64, userType int) error {
 	query := `
 			UPDATE users
 			SET type = ?
@@ -151,7 +146,7 @@ func (m UserModel) SetUserType(userID int64, userType int) error {
 	`
 	args := []interface{}{userType, userID}
 
-	_, err := m.DB.Exec(query, args...)
+	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -159,7 +154,7 @@ func (m UserModel) SetUserType(userID int64, userType int) error {
 	return nil
 }
 
-func (m UserModel) SetEmail(userID int64, email string) error {
+func (m UserModel) SetEmail(ctx context.Context, userID int64, email string) error {
 	query := `
 			UPDATE users
 			SET email = ?
@@ -167,7 +162,7 @@ func (m UserModel) SetEmail(userID int64, email string) error {
 	`
 	args := []interface{}{email, userID}
 
-	_, err := m.DB.Exec(query, args...)
+	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -175,7 +170,7 @@ func (m UserModel) SetEmail(userID int64, email string) error {
 	return nil
 }
 
-func (m UserModel) SetPassword(userID int64, passwordHash string) error {
+func (m UserModel) SetPassword(ctx context.Context, userID int64, passwordHash string) error {
 	query := `
 			UPDATE users
 			SET password_hash = ?
@@ -183,7 +178,7 @@ func (m UserModel) SetPassword(userID int64, passwordHash string) error {
 	`
 	args := []interface{}{passwordHash, userID}
 
-	_, err := m.DB.Exec(query, args...)
+	_, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -191,7 +186,7 @@ func (m UserModel) SetPassword(userID int64, passwordHash string) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	query := `
 		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.type
@@ -205,7 +200,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 	var user User
 
-	err := m.DB.QueryRow(query, args...).Scan(
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Username,
@@ -224,3 +219,42 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// Return the matching user.
 	return &user, nil
 }
+
+// Delete removes a user along with their short URLs and everything attached to
+// them and to the account, returning ErrRecordNotFound when there is no such user.
+func (m UserModel) Delete(ctx context.Context, userID int64) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Foreign keys are not enforced on every SQLite connection, so the rows
+	// referencing the user are removed explicitly.
+	for _, query := range []string{
+		`DELETE FROM analytics WHERE url_id IN (SELECT id FROM urls WHERE user_id = ?)`,
+		`DELETE FROM urls WHERE user_id = ?`,
+		`DELETE FROM tokens WHERE user_id = ?`,
+		`DELETE FROM privacy_settings WHERE user_id = ?`,
+		`DELETE FROM domains WHERE user_id = ?`,
+	} {
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,129 @@
+package domainverify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// RecordPrefix is prepended to a domain to name the TXT record holding its token.
+	RecordPrefix = "_chopper-verification."
+	// RecordValuePrefix starts the TXT record value, followed by the token.
+	RecordValuePrefix = "chopper-verification="
+	// WellKnownPath is where the token can be served over HTTP instead, as the whole body.
+	WellKnownPath = "/.well-known/chopper-verification"
+)
+
+// ErrNotVerified is returned when neither the TXT record nor the well-known path carries the token.
+var ErrNotVerified = errors.New("verification token not found")
+
+// Resolver looks up TXT records. *net.Resolver satisfies it.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Fetcher performs HTTP requests. *http.Client satisfies it.
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Verifier proves that whoever registered a domain controls it, either through
+// a DNS TXT record or a file served at the well-known HTTP path.
+type Verifier struct {
+	Resolver Resolver
+	Fetcher  Fetcher
+}
+
+// New returns a Verifier using the system resolver and an HTTP client that
+// gives up after timeout and does not follow redirects off the domain.
+func New(timeout time.Duration) *Verifier {
+	return &Verifier{
+		Resolver: net.DefaultResolver,
+		Fetcher: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 3 || req.URL.Hostname() != via[0].URL.Hostname() {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Verify returns the method ("dns" or "http") through which host proved it
+// carries token, or ErrNotVerified when neither did.
+func (v *Verifier) Verify(ctx context.Context, host string, token string) (string, error) {
+	if v.checkDNS(ctx, host, token) {
+		return "dns", nil
+	}
+	if v.checkHTTP(ctx, host, token) {
+		return "http", nil
+	}
+	return "", ErrNotVerified
+}
+
+// checkDNS looks for the token in the TXT records of the verification name.
+// Lookup failures count as a missing record.
+func (v *Verifier) checkDNS(ctx context.Context, host string, token string) bool {
+	if v.Resolver == nil {
+		return false
+	}
+
+	records, err := v.Resolver.LookupTXT(ctx, RecordPrefix+host)
+	if err != nil {
+		return false
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == RecordValuePrefix+token {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHTTP fetches the well-known path over HTTPS, then plain HTTP, and
+// compares the body with the token.
+func (v *Verifier) checkHTTP(ctx context.Context, host string, token string) bool {
+	if v.Fetcher == nil {
+		return false
+	}
+
+	for _, scheme := range []string{"https", "http"} {
+		body, err := v.fetch(ctx, fmt.Sprintf("%s://%s%s", scheme, host, WellKnownPath))
+		if err == nil && strings.TrimSpace(body) == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) fetch(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := v.Fetcher.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	// A token is short; anything past a few hundred bytes is not one.
+	body, err := io.ReadAll(io.LimitReader(res.Body, 512))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
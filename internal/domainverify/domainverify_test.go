@@ -0,0 +1,99 @@
+package domainverify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers TXT lookups from a map of names to records.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+// fakeFetcher serves bodies by URL with 200 OK, and fails for any other URL
+// as an unreachable server would. It records the URLs requested.
+type fakeFetcher struct {
+	bodies    map[string]string
+	requested []string
+}
+
+func (f *fakeFetcher) Do(req *http.Request) (*http.Response, error) {
+	f.requested = append(f.requested, req.URL.String())
+	body, ok := f.bodies[req.URL.String()]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestVerify(t *testing.T) {
+	const host, token = "links.example", "C4FN5QX6SJJFYVIMU2VIEMQYOY"
+
+	tests := []struct {
+		name     string
+		records  fakeResolver
+		bodies   map[string]string
+		want     string
+		wantErr  error
+		wantURLs []string
+	}{
+		{
+			name:    "matching TXT record",
+			records: fakeResolver{RecordPrefix + host: {"v=spf1 -all", RecordValuePrefix + token}},
+			want:    "dns",
+		},
+		{
+			name:    "wrong token",
+			records: fakeResolver{RecordPrefix + host: {RecordValuePrefix + "SOMEONEELSE"}},
+			bodies:  map[string]string{"https://" + host + WellKnownPath: "SOMEONEELSE"},
+			wantErr: ErrNotVerified,
+			wantURLs: []string{
+				"https://" + host + WellKnownPath,
+				"http://" + host + WellKnownPath,
+			},
+		},
+		{
+			name:     "well-known path over HTTPS",
+			bodies:   map[string]string{"https://" + host + WellKnownPath: token + "\n"},
+			want:     "http",
+			wantURLs: []string{"https://" + host + WellKnownPath},
+		},
+		{
+			name:   "well-known path falling back to HTTP",
+			bodies: map[string]string{"http://" + host + WellKnownPath: token},
+			want:   "http",
+			wantURLs: []string{
+				"https://" + host + WellKnownPath,
+				"http://" + host + WellKnownPath,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &fakeFetcher{bodies: tt.bodies}
+			v := &Verifier{Resolver: tt.records, Fetcher: fetcher}
+
+			method, err := v.Verify(context.Background(), host, token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if method != tt.want {
+				t.Errorf("got method %q, want %q", method, tt.want)
+			}
+			if strings.Join(fetcher.requested, " ") != strings.Join(tt.wantURLs, " ") {
+				t.Errorf("requested %v, want %v", fetcher.requested, tt.wantURLs)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Location is where an IP address was found to be.
+type Location struct {
+	Country string // ISO 3166-1 alpha-2 code
+	Region  string
+	City    string
+}
+
+// DB resolves IP addresses using a MaxMind-format (.mmdb) city or country database.
+// A nil *DB is valid and resolves every address to an empty Location.
+type DB struct {
+	reader *geoip2.Reader
+}
+
+// Open loads the database file at path.
+func Open(path string) (*DB, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Lookup returns the location of ip. Addresses that cannot be parsed or are
+// missing from the database resolve to an empty Location.
+func (db *DB) Lookup(ip string) Location {
+	if db == nil {
+		return Location{}
+	}
+
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return Location{}
+	}
+
+	record, err := db.reader.City(addr)
+	if err != nil {
+		// Country-only databases reject City lookups.
+		var invalidMethod geoip2.InvalidMethodError
+		if !errors.As(err, &invalidMethod) {
+			return Location{}
+		}
+		country, err := db.reader.Country(addr)
+		if err != nil {
+			return Location{}
+		}
+		return Location{Country: country.Country.IsoCode}
+	}
+
+	location := Location{
+		Country: record.Country.IsoCode,
+		City:    record.City.Names["en"],
+	}
+	if len(record.Subdivisions) > 0 {
+		location.Region = record.Subdivisions[0].Names["en"]
+	}
+	return location
+}
+
+// Close releases the database file.
+func (db *DB) Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.reader.Close()
+}
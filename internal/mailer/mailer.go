@@ -2,6 +2,7 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"text/template"
 	"time"
@@ -26,7 +27,9 @@ func New(host string, port int, username string, password string, sender string)
 	}
 }
 
-func (m Mailer) Send(recipient string, templateFile string, data interface{}) error {
+// Send renders templateFile for data and sends it to recipient, retrying twice.
+// It gives up early, returning the context's error, once ctx is done.
+func (m Mailer) Send(ctx context.Context, recipient string, templateFile string, data interface{}) error {
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
 		return err
@@ -62,8 +65,25 @@ func (m Mailer) Send(recipient string, templateFile string, data interface{}) er
 		if nil == err {
 			return nil
 		}
-		time.Sleep(500 * time.Millisecond)
+		if i == 3 {
+			break
+		}
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
-	return nil
+	return err
+}
+
+// Ping connects to the SMTP server and authenticates, without sending anything.
+func (m Mailer) Ping() error {
+	conn, err := m.dialer.Dial()
+	if err != nil {
+		return err
+	}
+	return conn.Close()
 }
@@ -0,0 +1,146 @@
+package useragent
+
+import "strings"
+
+// Device classes reported by Parse.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// Other is reported when a browser or operating system is not recognised.
+const Other = "Other"
+
+// Agent is the parsed form of a User-Agent header.
+type Agent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	Device         string
+}
+
+// botSignatures are lower-cased substrings found in the user agents of crawlers,
+// link-preview fetchers and command line clients. Slackbot, Twitterbot,
+// Discordbot, LinkedInBot and TelegramBot are all covered by "bot".
+var botSignatures = []string{
+	"bot", "crawler", "spider", "slurp", "facebookexternalhit", "facebookcatalog",
+	"embedly", "iframely", "preview", "whatsapp", "skypeuripreview", "vkshare",
+	"pinterest", "mastodon", "headless", "lighthouse", "curl/", "wget/",
+	"python-requests", "python-urllib", "go-http-client", "okhttp", "java/",
+	"libwww-perl", "httpclient", "axios/", "node-fetch",
+}
+
+// browsers are checked in order, since most user agents mention several
+// engines (every Chrome user agent also claims to be Safari, for example).
+var browsers = []struct {
+	name   string
+	tokens []string
+}{
+	{"Edge", []string{"Edg/", "EdgA/", "EdgiOS/", "Edge/"}},
+	{"Opera", []string{"OPR/", "Opera/"}},
+	{"Samsung Internet", []string{"SamsungBrowser/"}},
+	{"Firefox", []string{"Firefox/", "FxiOS/"}},
+	{"Chromium", []string{"Chromium/"}},
+	{"Chrome", []string{"CriOS/", "Chrome/"}},
+	{"Internet Explorer", []string{"MSIE ", "Trident/"}},
+	{"Safari", []string{"Version/"}},
+}
+
+var systems = []struct {
+	name   string
+	tokens []string
+}{
+	{"Windows", []string{"Windows"}},
+	{"iOS", []string{"iPhone", "iPad", "iPod"}},
+	{"Android", []string{"Android"}},
+	{"Chrome OS", []string{"CrOS"}},
+	{"macOS", []string{"Macintosh", "Mac OS X"}},
+	{"Linux", []string{"Linux"}},
+}
+
+// IsBot reports whether the user agent belongs to an automated client.
+// An empty user agent is treated as a bot, as browsers always send one.
+func IsBot(userAgent string) bool {
+	if strings.TrimSpace(userAgent) == "" {
+		return true
+	}
+	lower := strings.ToLower(userAgent)
+	for _, signature := range botSignatures {
+		if strings.Contains(lower, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse extracts the browser, operating system and device class from a User-Agent header.
+func Parse(userAgent string) Agent {
+	agent := Agent{
+		Browser: Other,
+		OS:      Other,
+	}
+
+	for _, browser := range browsers {
+		if version, ok := findVersion(userAgent, browser.tokens); ok {
+			agent.Browser = browser.name
+			agent.BrowserVersion = version
+			break
+		}
+	}
+	// Safari is only Safari when it does not carry another browser's token.
+	if agent.Browser == "Safari" && !strings.Contains(userAgent, "Safari/") {
+		agent.Browser = Other
+		agent.BrowserVersion = ""
+	}
+
+	for _, system := range systems {
+		if containsAny(userAgent, system.tokens) {
+			agent.OS = system.name
+			break
+		}
+	}
+
+	switch {
+	case IsBot(userAgent):
+		agent.Device = DeviceBot
+	case containsAny(userAgent, []string{"iPad", "Tablet"}) || (agent.OS == "Android" && !strings.Contains(userAgent, "Mobile")):
+		agent.Device = DeviceTablet
+	case containsAny(userAgent, []string{"Mobi", "iPhone", "iPod"}):
+		agent.Device = DeviceMobile
+	default:
+		agent.Device = DeviceDesktop
+	}
+
+	return agent
+}
+
+// findVersion looks for the first token present in userAgent and returns the
+// version number that follows it.
+func findVersion(userAgent string, tokens []string) (string, bool) {
+	for _, token := range tokens {
+		i := strings.Index(userAgent, token)
+		if i < 0 {
+			continue
+		}
+		rest := userAgent[i+len(token):]
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		return rest[:end], true
+	}
+	return "", false
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, token := range tokens {
+		if strings.Contains(s, token) {
+			return true
+		}
+	}
+	return false
+}
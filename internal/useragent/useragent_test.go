@@ -0,0 +1,105 @@
+package useragent
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      Agent
+		wantBot   bool
+	}{
+		{
+			name:      "Chrome on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.91 Safari/537.36",
+			want:      Agent{Browser: "Chrome", BrowserVersion: "124.0.6367.91", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			name:      "Chrome on Android",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.82 Mobile Safari/537.36",
+			want:      Agent{Browser: "Chrome", BrowserVersion: "124.0.6367.82", OS: "Android", Device: DeviceMobile},
+		},
+		{
+			name:      "Safari on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Mobile/15E148 Safari/604.1",
+			want:      Agent{Browser: "Safari", BrowserVersion: "17.4.1", OS: "iOS", Device: DeviceMobile},
+		},
+		{
+			name:      "Safari on iPad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			want:      Agent{Browser: "Safari", BrowserVersion: "16.6", OS: "iOS", Device: DeviceTablet},
+		},
+		{
+			name:      "Chrome on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+			want:      Agent{Browser: "Chrome", BrowserVersion: "124.0.6367.88", OS: "iOS", Device: DeviceMobile},
+		},
+		{
+			name:      "Safari on macOS",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+			want:      Agent{Browser: "Safari", BrowserVersion: "17.4.1", OS: "macOS", Device: DeviceDesktop},
+		},
+		{
+			name:      "Edge on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.67",
+			want:      Agent{Browser: "Edge", BrowserVersion: "124.0.2478.67", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			name:      "Firefox on Linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want:      Agent{Browser: "Firefox", BrowserVersion: "125.0", OS: "Linux", Device: DeviceDesktop},
+		},
+		{
+			name:      "Googlebot",
+			userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want:      Agent{Browser: Other, OS: Other, Device: DeviceBot},
+			wantBot:   true,
+		},
+		{
+			name:      "Googlebot smartphone",
+			userAgent: "Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.91 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want:      Agent{Browser: "Chrome", BrowserVersion: "124.0.6367.91", OS: "Android", Device: DeviceBot},
+			wantBot:   true,
+		},
+		{
+			name:      "Slack link preview",
+			userAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+			want:      Agent{Browser: Other, OS: Other, Device: DeviceBot},
+			wantBot:   true,
+		},
+		{
+			name:      "curl",
+			userAgent: "curl/8.5.0",
+			want:      Agent{Browser: Other, OS: Other, Device: DeviceBot},
+			wantBot:   true,
+		},
+		{
+			name:      "empty",
+			userAgent: "",
+			want:      Agent{Browser: Other, OS: Other, Device: DeviceBot},
+			wantBot:   true,
+		},
+		{
+			name:      "blank",
+			userAgent: "   ",
+			want:      Agent{Browser: Other, OS: Other, Device: DeviceBot},
+			wantBot:   true,
+		},
+		{
+			name:      "Version token without Safari",
+			userAgent: "SomeApp Version/2.0 (Windows)",
+			want:      Agent{Browser: Other, OS: "Windows", Device: DeviceDesktop},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.userAgent); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got := IsBot(tt.userAgent); got != tt.wantBot {
+				t.Errorf("IsBot = %t, want %t", got, tt.wantBot)
+			}
+		})
+	}
+}
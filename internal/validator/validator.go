@@ -35,3 +35,13 @@ func (v *Validator) Check(ok bool, key string, message string) {
 func (v *Validator) Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
+
+// In reports whether value is one of list.
+func (v *Validator) In(value string, list ...string) bool {
+	for _, item := range list {
+		if value == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+CREATE TABLE urls_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    long_url TEXT NOT NULL,
+    short_url TEXT NOT NULL,
+    accessed INTEGER DEFAULT 0,
+    redirect INTEGER DEFAULT 308,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    UNIQUE(short_url)
+);
+
+INSERT INTO urls_old (id, long_url, short_url, accessed, redirect, user_id)
+SELECT id, long_url, short_url, accessed, redirect, user_id FROM urls;
+
+DROP TABLE urls;
+
+ALTER TABLE urls_old RENAME TO urls;
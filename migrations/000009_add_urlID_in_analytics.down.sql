@@ -0,0 +1,16 @@
+CREATE TABLE analytics_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    short_url TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    referrer TEXT,
+    timestamp DATETIME NOT NULL
+);
+
+-- The up migration took url_id from short_url, so it goes back there.
+INSERT INTO analytics_old (id, short_url, ip, user_agent, referrer, timestamp)
+SELECT id, url_id, ip, user_agent, referrer, timestamp FROM analytics;
+
+DROP TABLE analytics;
+
+ALTER TABLE analytics_old RENAME TO analytics;
@@ -0,0 +1,20 @@
+CREATE TABLE urls_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    long_url TEXT NOT NULL,
+    short_url TEXT NOT NULL,
+    accessed INTEGER DEFAULT 0,
+    redirect INTEGER DEFAULT 301,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    UNIQUE(short_url)
+);
+
+-- The access counter was not kept, so it starts over.
+INSERT INTO urls_old (id, long_url, short_url, accessed, redirect, user_id, created, modified)
+SELECT id, long_url, short_url, 0, redirect, user_id, created, modified FROM urls;
+
+DROP TABLE urls;
+
+ALTER TABLE urls_old RENAME TO urls;
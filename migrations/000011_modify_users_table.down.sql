@@ -0,0 +1,19 @@
+CREATE TABLE users_old (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    name TEXT NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    password_hash BLOB NOT NULL,
+    activated INTEGER NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+-- The user type was carried over from version, so it goes back there.
+INSERT INTO users_old (id, created_at, name, email, password_hash, activated, version)
+SELECT id, created_at, name, email, password_hash, 1, type FROM users;
+
+DROP TABLE users;
+
+ALTER TABLE users_old RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_user_id ON users(id);
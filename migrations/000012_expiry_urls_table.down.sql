@@ -0,0 +1,18 @@
+CREATE TABLE urls_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    long_url TEXT NOT NULL,
+    short_url TEXT NOT NULL,
+    redirect INTEGER DEFAULT 308,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    UNIQUE(short_url)
+);
+
+INSERT INTO urls_old (id, long_url, short_url, redirect, user_id, created, modified)
+SELECT id, long_url, short_url, redirect, user_id, created, expired FROM urls;
+
+DROP TABLE urls;
+
+ALTER TABLE urls_old RENAME TO urls;
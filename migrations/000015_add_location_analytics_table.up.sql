@@ -0,0 +1,3 @@
+ALTER TABLE analytics ADD COLUMN country TEXT NOT NULL DEFAULT '';
+ALTER TABLE analytics ADD COLUMN region TEXT NOT NULL DEFAULT '';
+ALTER TABLE analytics ADD COLUMN city TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE analytics ADD COLUMN bot INTEGER NOT NULL DEFAULT 0;
+UPDATE analytics SET bot = 1 WHERE device = 'bot';
@@ -0,0 +1,22 @@
+-- Links on other domains cannot keep their codes once codes are unique again, so they are dropped.
+CREATE TABLE urls_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    long_url TEXT NOT NULL,
+    short_url TEXT NOT NULL,
+    redirect INTEGER DEFAULT 308,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expired TIMESTAMP ,
+    once INTEGER DEFAULT 0,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    UNIQUE(short_url)
+);
+
+INSERT INTO urls_new (id, long_url, short_url, redirect, user_id, created, expired, once)
+SELECT id, long_url, short_url, redirect, user_id, created, expired, once FROM urls WHERE domain = '';
+
+DROP TABLE urls;
+
+ALTER TABLE urls_new RENAME TO urls;
+
+CREATE INDEX IF NOT EXISTS idx_long_url ON urls(long_url);
@@ -0,0 +1,24 @@
+-- Short codes are unique per domain; the empty domain is the deployment's default.
+CREATE TABLE urls_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    long_url TEXT NOT NULL,
+    short_url TEXT NOT NULL,
+    domain TEXT NOT NULL DEFAULT '',
+    redirect INTEGER DEFAULT 308,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expired TIMESTAMP ,
+    once INTEGER DEFAULT 0,
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    UNIQUE(domain, short_url)
+);
+
+INSERT INTO urls_new (id, long_url, short_url, domain, redirect, user_id, created, expired, once)
+SELECT id, long_url, short_url, '', redirect, user_id, created, expired, once FROM urls;
+
+DROP TABLE urls;
+
+ALTER TABLE urls_new RENAME TO urls;
+
+CREATE INDEX IF NOT EXISTS idx_long_url ON urls(long_url);
+CREATE INDEX IF NOT EXISTS idx_urls_user_id ON urls(user_id);
@@ -0,0 +1,17 @@
+CREATE TABLE domains_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    host TEXT NOT NULL UNIQUE,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Keep one claim per host, preferring the verified one.
+INSERT INTO domains_old (id, user_id, host, created)
+SELECT id, user_id, host, created FROM domains d
+WHERE id = (SELECT id FROM domains WHERE host = d.host ORDER BY verified DESC, id LIMIT 1);
+
+DROP TABLE domains;
+
+ALTER TABLE domains_old RENAME TO domains;
+
+CREATE INDEX IF NOT EXISTS idx_domains_user_id ON domains(user_id);
@@ -0,0 +1,20 @@
+-- Anyone may claim a host, but only one claim can be verified; the token proves control of it.
+CREATE TABLE domains_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    host TEXT NOT NULL,
+    verification_token TEXT NOT NULL,
+    verified INTEGER NOT NULL DEFAULT 0,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, host)
+);
+
+INSERT INTO domains_new (id, user_id, host, verification_token, verified, created)
+SELECT id, user_id, host, upper(hex(randomblob(16))), 0, created FROM domains;
+
+DROP TABLE domains;
+
+ALTER TABLE domains_new RENAME TO domains;
+
+CREATE INDEX IF NOT EXISTS idx_domains_user_id ON domains(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_verified_host ON domains(host) WHERE verified = 1;
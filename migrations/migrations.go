@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations into the binary, the SQLite set
+// at its root and the PostgreSQL set under postgres/.
+package migrations
+
+import "embed"
+
+//go:embed *.sql postgres/*.sql
+var FS embed.FS
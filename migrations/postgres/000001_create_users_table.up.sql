@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    name TEXT NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    password_hash BYTEA NOT NULL,
+    type INTEGER NOT NULL CHECK (type IN (0, 1, 2)) DEFAULT 1
+);
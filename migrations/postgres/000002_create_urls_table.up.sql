@@ -0,0 +1,16 @@
+-- user_id carries no foreign key: anonymous links are stored with user_id 0,
+-- which has no matching row in users.
+CREATE TABLE IF NOT EXISTS urls (
+    id BIGSERIAL PRIMARY KEY,
+    long_url TEXT NOT NULL,
+    short_url TEXT NOT NULL,
+    redirect INTEGER DEFAULT 308,
+    user_id BIGINT NOT NULL DEFAULT 0,
+    created TIMESTAMPTZ DEFAULT NOW(),
+    expired TIMESTAMPTZ,
+    once BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE(short_url)
+);
+
+CREATE INDEX IF NOT EXISTS idx_long_url ON urls(long_url);
+CREATE INDEX IF NOT EXISTS idx_urls_user_id ON urls(user_id);
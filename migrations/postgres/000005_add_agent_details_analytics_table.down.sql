@@ -0,0 +1,4 @@
+ALTER TABLE analytics DROP COLUMN device;
+ALTER TABLE analytics DROP COLUMN os;
+ALTER TABLE analytics DROP COLUMN browser_version;
+ALTER TABLE analytics DROP COLUMN browser;
@@ -0,0 +1,4 @@
+ALTER TABLE analytics ADD COLUMN browser TEXT NOT NULL DEFAULT '';
+ALTER TABLE analytics ADD COLUMN browser_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE analytics ADD COLUMN os TEXT NOT NULL DEFAULT '';
+ALTER TABLE analytics ADD COLUMN device TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE analytics DROP COLUMN city;
+ALTER TABLE analytics DROP COLUMN region;
+ALTER TABLE analytics DROP COLUMN country;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS privacy_settings (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    ip_mode TEXT NOT NULL DEFAULT '',
+    retention_days INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,2 @@
+ALTER TABLE analytics ADD COLUMN bot BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE analytics SET bot = TRUE WHERE device = 'bot';
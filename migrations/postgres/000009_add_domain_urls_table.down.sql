@@ -0,0 +1,5 @@
+-- Links on other domains cannot keep their codes once codes are unique again, so they are dropped.
+DELETE FROM urls WHERE domain <> '';
+ALTER TABLE urls DROP CONSTRAINT urls_domain_short_url_key;
+ALTER TABLE urls ADD CONSTRAINT urls_short_url_key UNIQUE (short_url);
+ALTER TABLE urls DROP COLUMN domain;
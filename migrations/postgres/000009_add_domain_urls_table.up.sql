@@ -0,0 +1,4 @@
+-- Short codes are unique per domain; the empty domain is the deployment's default.
+ALTER TABLE urls ADD COLUMN domain TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls DROP CONSTRAINT urls_short_url_key;
+ALTER TABLE urls ADD CONSTRAINT urls_domain_short_url_key UNIQUE (domain, short_url);
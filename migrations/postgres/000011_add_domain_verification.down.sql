@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_domains_verified_host;
+-- Keep one claim per host, preferring the verified one.
+DELETE FROM domains d
+WHERE id <> (SELECT id FROM domains WHERE host = d.host ORDER BY verified DESC, id LIMIT 1);
+ALTER TABLE domains DROP CONSTRAINT domains_user_id_host_key;
+ALTER TABLE domains ADD CONSTRAINT domains_host_key UNIQUE (host);
+ALTER TABLE domains DROP COLUMN verified;
+ALTER TABLE domains DROP COLUMN verification_token;
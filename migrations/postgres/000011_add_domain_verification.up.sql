@@ -0,0 +1,8 @@
+-- Anyone may claim a host, but only one claim can be verified; the token proves control of it.
+ALTER TABLE domains ADD COLUMN verification_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE domains ADD COLUMN verified BOOLEAN NOT NULL DEFAULT false;
+UPDATE domains SET verification_token = upper(md5(random()::text || id::text));
+ALTER TABLE domains ALTER COLUMN verification_token DROP DEFAULT;
+ALTER TABLE domains DROP CONSTRAINT domains_host_key;
+ALTER TABLE domains ADD CONSTRAINT domains_user_id_host_key UNIQUE (user_id, host);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_verified_host ON domains(host) WHERE verified;